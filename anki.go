@@ -2,27 +2,59 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
-
-	"github.com/dstotijn/go-notion"
 )
 
 var ErrAnkiConnectFailed = errors.New("anki: could not connect to AnkiConnect")
 
+var errAnkiReadOnly = errors.New("AnkiConnect is read-only during a dry run")
+
+var readOnlyActions = map[string]bool{
+	"version":            true,
+	"deckNames":          true,
+	"modelNames":         true,
+	"modelFieldNames":    true,
+	"modelTemplates":     true,
+	"findNotes":          true,
+	"notesInfo":          true,
+	"cardsInfo":          true,
+	"getReviewsOfCards":  true,
+	"canAddNotes":        true,
+	"getMediaFilesNames": true,
+}
+
+const (
+	notionTag       = "notion"
+	notionTagPrefix = "notion:"
+)
+
 type Anki struct {
-	Config AnkiConfig
+	Config          AnkiConfig
+	templatesSynced bool
+	decks           map[string]bool
+	logger          *log.Logger
+	readOnly        bool
+	pendingModel    bool
+	pendingFields   []string
+	pendingRenames  map[string]string
+	pendingDecks    map[string]bool
 }
 
 type AnkiConfig struct {
 	AnkiConnectURL string `json:"anki_connect_url"`
 	DeckName       string `json:"deck_name"`
 	ModelName      string `json:"model_name"`
+	Templates      CardTemplateConfig
 	httpClient     *http.Client
 }
 
@@ -42,6 +74,22 @@ type AnkiNote struct {
 	ModelName string            `json:"modelName"`
 	Fields    map[string]string `json:"fields"`
 	Tags      []string          `json:"tags"`
+	Options   *AnkiNoteOptions  `json:"options,omitempty"`
+}
+
+// AnkiNoteOptions lets notes be added even when their first field matches
+// another note. Notes are identified by their page tag, so pages with the
+// same first field get a note each.
+type AnkiNoteOptions struct {
+	AllowDuplicate bool `json:"allowDuplicate"`
+}
+
+type SyncNote struct {
+	PageID      string
+	Deck        string
+	Fields      map[string]string
+	PlainFields map[string]string
+	Tags        []string
 }
 
 type AddNotesParams struct {
@@ -56,16 +104,27 @@ func NewAnki(url, deckName, modelName string) *Anki {
 			ModelName:      modelName,
 			httpClient:     &http.Client{Timeout: 30 * time.Second},
 		},
+		logger: log.Default(),
 	}
 }
 
-func (anki *Anki) makeJSONRequest(payload interface{}, result interface{}) error {
+func (anki *Anki) makeJSONRequest(ctx context.Context, payload interface{}, result interface{}) error {
+	if request, ok := payload.(AnkiConnectRequest); ok && anki.readOnly && !readOnlyActions[request.Action] {
+		return fmt.Errorf("%w: %s", errAnkiReadOnly, request.Action)
+	}
+
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("fail to serialize request: %v", err)
 	}
 
-	resp, err := anki.Config.httpClient.Post(anki.Config.AnkiConnectURL, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, anki.Config.AnkiConnectURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("fail to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := anki.Config.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("fail to send request: %v", err)
 	}
@@ -79,7 +138,7 @@ func (anki *Anki) makeJSONRequest(payload interface{}, result interface{}) error
 	return json.NewDecoder(resp.Body).Decode(result)
 }
 
-func (anki *Anki) CheckAnkiConnect() error {
+func (anki *Anki) CheckAnkiConnect(ctx context.Context) error {
 	request := AnkiConnectRequest{
 		Action:  "version",
 		Version: 6,
@@ -87,7 +146,7 @@ func (anki *Anki) CheckAnkiConnect() error {
 	}
 
 	var response AnkiConnectResponse
-	err := anki.makeJSONRequest(request, &response)
+	err := anki.makeJSONRequest(ctx, request, &response)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrAnkiConnectFailed, err)
 	}
@@ -99,7 +158,7 @@ func (anki *Anki) CheckAnkiConnect() error {
 	return nil
 }
 
-func (anki *Anki) CreateDeck(deckName string) error {
+func (anki *Anki) CreateDeck(ctx context.Context, deckName string) error {
 	if deckName == "" {
 		return fmt.Errorf("no deck name provided")
 	}
@@ -113,7 +172,7 @@ func (anki *Anki) CreateDeck(deckName string) error {
 	}
 
 	var response AnkiConnectResponse
-	err := anki.makeJSONRequest(request, &response)
+	err := anki.makeJSONRequest(ctx, request, &response)
 	if err != nil {
 		return fmt.Errorf("fail to create deck: %v", err)
 	}
@@ -121,50 +180,86 @@ func (anki *Anki) CreateDeck(deckName string) error {
 	if response.Error != nil {
 		return fmt.Errorf("AnkiConnect deck creation error: %v", response.Error)
 	}
-	log.Printf("Successfully created deck: %s", deckName)
+	anki.logger.Printf("Successfully created deck: %s", deckName)
 
 	return nil
 }
 
-func (anki *Anki) EnsureDeckExists() error {
-	deckName := anki.Config.DeckName
-	if deckName != "" {
-		request := AnkiConnectRequest{
-			Action:  "deckNames",
-			Params:  map[string]interface{}{},
-			Version: 6,
+func (anki *Anki) DeckNames(ctx context.Context) ([]string, error) {
+	request := AnkiConnectRequest{
+		Action:  "deckNames",
+		Params:  map[string]interface{}{},
+		Version: 6,
+	}
+
+	var response AnkiConnectResponse
+	err := anki.makeJSONRequest(ctx, request, &response)
+	if err != nil {
+		return nil, fmt.Errorf("fail to check existing decks: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("AnkiConnect deck exist error: %v", response.Error)
+	}
+
+	deckNames, ok := response.Result.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid response format: %v", response.Result)
+	}
+
+	names := make([]string, 0, len(deckNames))
+	for _, name := range deckNames {
+		if name, ok := name.(string); ok {
+			names = append(names, name)
 		}
+	}
+	return names, nil
+}
+
+func (anki *Anki) EnsureDeck(ctx context.Context, deckName string) error {
+	if deckName == "" {
+		return fmt.Errorf("no deck name provided")
+	}
 
-		var response AnkiConnectResponse
-		err := anki.makeJSONRequest(request, &response)
+	if anki.decks == nil {
+		names, err := anki.DeckNames(ctx)
 		if err != nil {
-			return fmt.Errorf("fail to check existing decks: %v", err)
+			return err
 		}
-
-		if response.Error != nil {
-			return fmt.Errorf("AnkiConnect deck exist error: %v", response.Error)
+		anki.decks = make(map[string]bool, len(names))
+		for _, name := range names {
+			anki.decks[name] = true
 		}
+	}
 
-		deckNames, ok := response.Result.([]interface{})
-		if !ok {
-			return fmt.Errorf("invalid response format: %v", response.Result)
-		}
+	if anki.decks[deckName] {
+		return nil
+	}
 
-		for _, name := range deckNames {
-			if name == deckName {
-				log.Printf("Deck already exists: %s", deckName)
-				return nil
-			}
+	if anki.readOnly {
+		anki.logger.Printf("Dry run: deck %s would be created", deckName)
+		if anki.pendingDecks == nil {
+			anki.pendingDecks = map[string]bool{}
 		}
+		anki.pendingDecks[deckName] = true
+		anki.decks[deckName] = true
+		return nil
+	}
 
-		log.Printf("Deck does not exist, creating: %s", deckName)
-		return anki.CreateDeck(deckName)
+	anki.logger.Printf("Deck does not exist, creating: %s", deckName)
+	if err := anki.CreateDeck(ctx, deckName); err != nil {
+		return err
 	}
+	anki.decks[deckName] = true
+	return nil
+}
 
-	return fmt.Errorf("no deck name provided")
+func (anki *Anki) EnsureDeckExists(ctx context.Context) error {
+	anki.decks = nil
+	return anki.EnsureDeck(ctx, anki.Config.DeckName)
 }
 
-func (anki *Anki) EnsureModelExists(pageProperties notion.DatabasePageProperties) error {
+func (anki *Anki) EnsureModelExists(ctx context.Context, fields []string, renames map[string]string) error {
 	configModelName := anki.Config.ModelName
 	request := AnkiConnectRequest{
 		Action:  "modelNames",
@@ -173,7 +268,7 @@ func (anki *Anki) EnsureModelExists(pageProperties notion.DatabasePageProperties
 	}
 
 	var response AnkiConnectResponse
-	err := anki.makeJSONRequest(request, &response)
+	err := anki.makeJSONRequest(ctx, request, &response)
 	if err != nil {
 		return fmt.Errorf("fail to check existing models: %v", err)
 	}
@@ -189,41 +284,47 @@ func (anki *Anki) EnsureModelExists(pageProperties notion.DatabasePageProperties
 
 	for _, name := range modelNames {
 		if name == configModelName {
-			log.Printf("Model already exists: %s", configModelName)
-			return nil
+			anki.logger.Printf("Model already exists: %s", configModelName)
+			return anki.reconcileModel(ctx, fields, renames)
 		}
 	}
 
-	log.Printf("Model does not exist, creating: %s", configModelName)
-	fields := []string{}
-	for name := range pageProperties {
-		fields = append(fields, name)
+	if anki.readOnly {
+		anki.logger.Printf("Dry run: model %s would be created with fields %v", configModelName, fields)
+		anki.pendingModel = true
+		return nil
+	}
+
+	anki.logger.Printf("Model does not exist, creating: %s", configModelName)
+	if err := anki.createModel(ctx, configModelName, fields); err != nil {
+		return err
 	}
-	return anki.createModel(configModelName, fields)
+	anki.templatesSynced = true
+	return nil
 }
 
-func (anki *Anki) createModel(modelName string, fields []string) error {
+func (anki *Anki) createModel(ctx context.Context, modelName string, fields []string) error {
 	if modelName == "" {
 		return fmt.Errorf("no model name provided")
 	}
+	templates, css, err := anki.Config.Templates.Build(fields)
+	if err != nil {
+		return fmt.Errorf("fail to build card templates: %v", err)
+	}
 	request := AnkiConnectRequest{
 		Action:  "createModel",
 		Version: 6,
 		Params: map[string]any{
 			"modelName":     modelName,
 			"inOrderFields": fields,
-			"cardTemplates": []map[string]any{
-				{
-					"Name":  "Card 2",
-					"Front": "{{}}",
-					"Back":  "{{}}",
-				},
-			},
+			"css":           css,
+			"isCloze":       false,
+			"cardTemplates": templates,
 		},
 	}
 
 	var response AnkiConnectResponse
-	err := anki.makeJSONRequest(request, &response)
+	err = anki.makeJSONRequest(ctx, request, &response)
 	if err != nil {
 		return fmt.Errorf("fail to create model: %v", err)
 	}
@@ -232,18 +333,30 @@ func (anki *Anki) createModel(modelName string, fields []string) error {
 		return fmt.Errorf("AnkiConnect model creation error: %v", response.Error)
 	}
 
-	log.Printf("Successfully created model: %s", modelName)
+	anki.logger.Printf("Successfully created model: %s", modelName)
 	return nil
 }
 
-func (anki *Anki) AddNotesToDeck(fields []map[string]string) error {
+func (anki *Anki) noteDeck(note SyncNote) string {
+	if note.Deck != "" {
+		return note.Deck
+	}
+	return anki.Config.DeckName
+}
+
+func (anki *Anki) AddNotesToDeck(ctx context.Context, notes []SyncNote) ([]int64, error) {
 	var ankiNotes []AnkiNote
-	for _, noteFields := range fields {
+	for _, note := range notes {
+		deckName := anki.noteDeck(note)
+		if err := anki.EnsureDeck(ctx, deckName); err != nil {
+			return nil, err
+		}
 		ankiNotes = append(ankiNotes, AnkiNote{
-			DeckName:  anki.Config.DeckName,
+			DeckName:  deckName,
 			ModelName: anki.Config.ModelName,
-			Fields:    noteFields,
-			Tags:      []string{"notion"},
+			Fields:    note.Fields,
+			Tags:      noteTags(note.PageID, note.Tags),
+			Options:   &AnkiNoteOptions{AllowDuplicate: true},
 		})
 	}
 
@@ -255,36 +368,63 @@ func (anki *Anki) AddNotesToDeck(fields []map[string]string) error {
 		},
 	}
 
-	var response AnkiConnectResponse
-	err := anki.makeJSONRequest(request, &response)
+	var response addNotesResponse
+	err := anki.makeJSONRequest(ctx, request, &response)
 	if err != nil {
-		log.Printf("fail to add note: %v", err)
+		return nil, fmt.Errorf("fail to add note: %v", err)
 	}
 
 	if response.Error != nil {
-		log.Printf("AnkiConnect error: %v", response.Error)
+		return nil, fmt.Errorf("AnkiConnect error: %v", response.Error)
+	}
+
+	noteIDs := make([]int64, len(ankiNotes))
+	added := map[string]int{}
+	var decks []string
+	for i, noteID := range response.Result {
+		if i < len(noteIDs) && noteID != nil {
+			noteIDs[i] = *noteID
+			deck := ankiNotes[i].DeckName
+			if added[deck] == 0 {
+				decks = append(decks, deck)
+			}
+			added[deck]++
+		}
 	}
 
-	log.Printf("Successfully added note to deck: %s", anki.Config.DeckName)
-	return nil
+	sort.Strings(decks)
+	for _, deck := range decks {
+		anki.logger.Printf("Successfully added %d notes to deck: %s", added[deck], deck)
+	}
+	return noteIDs, nil
 }
 
-func (anki *Anki) CanAddNotes(fields map[string]string) (bool, error) {
+func (anki *Anki) CanAddNotes(ctx context.Context, note SyncNote) (bool, error) {
+	if anki.readOnly && (anki.pendingModel || anki.pendingDecks[anki.noteDeck(note)]) {
+		return true, nil
+	}
+	// The note type would get new fields before notes are added. Duplicates
+	// are allowed, so Anki would only reject a note with an empty first field.
+	if anki.readOnly && len(anki.pendingFields) > 0 {
+		return strings.TrimSpace(note.Fields[anki.pendingFields[0]]) != "", nil
+	}
+
 	request := AnkiConnectRequest{
 		Action:  "canAddNotes",
 		Version: 6,
 		Params: AddNotesParams{
 			Notes: []AnkiNote{{
-				DeckName:  anki.Config.DeckName,
+				DeckName:  anki.noteDeck(note),
 				ModelName: anki.Config.ModelName,
-				Fields:    fields,
-				Tags:      []string{"notion"},
+				Fields:    note.Fields,
+				Tags:      noteTags(note.PageID, note.Tags),
+				Options:   &AnkiNoteOptions{AllowDuplicate: true},
 			}},
 		},
 	}
 
 	var response AnkiConnectResponse
-	err := anki.makeJSONRequest(request, &response)
+	err := anki.makeJSONRequest(ctx, request, &response)
 	if err != nil {
 		return false, fmt.Errorf("fail to fetch notes by deck: %v", err)
 	}
@@ -313,3 +453,487 @@ func (anki *Anki) CanAddNotes(fields map[string]string) (bool, error) {
 
 	return indicators[0], nil
 }
+
+type AnkiNoteField struct {
+	Value string `json:"value"`
+	Order int    `json:"order"`
+}
+
+type AnkiNoteInfo struct {
+	NoteID    int64                    `json:"noteId"`
+	ModelName string                   `json:"modelName"`
+	Tags      []string                 `json:"tags"`
+	Fields    map[string]AnkiNoteField `json:"fields"`
+	Cards     []int64                  `json:"cards"`
+}
+
+type addNotesResponse struct {
+	Result []*int64    `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+type findNotesResponse struct {
+	Result []int64     `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+type notesInfoResponse struct {
+	Result []AnkiNoteInfo `json:"result"`
+	Error  interface{}    `json:"error"`
+}
+
+type modelFieldNamesResponse struct {
+	Result []string    `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+func (anki *Anki) ModelFieldNames(ctx context.Context, modelName string) ([]string, error) {
+	request := AnkiConnectRequest{
+		Action:  "modelFieldNames",
+		Version: 6,
+		Params: map[string]interface{}{
+			"modelName": modelName,
+		},
+	}
+
+	var response modelFieldNamesResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return nil, fmt.Errorf("fail to fetch model fields: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("AnkiConnect model fields error: %v", response.Error)
+	}
+
+	return response.Result, nil
+}
+
+func (anki *Anki) FindNotes(ctx context.Context, query string) ([]int64, error) {
+	request := AnkiConnectRequest{
+		Action:  "findNotes",
+		Version: 6,
+		Params: map[string]interface{}{
+			"query": query,
+		},
+	}
+
+	var response findNotesResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return nil, fmt.Errorf("fail to find notes: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("AnkiConnect find notes error: %v", response.Error)
+	}
+
+	return response.Result, nil
+}
+
+func (anki *Anki) NotesInfo(ctx context.Context, noteIDs []int64) ([]AnkiNoteInfo, error) {
+	request := AnkiConnectRequest{
+		Action:  "notesInfo",
+		Version: 6,
+		Params: map[string]interface{}{
+			"notes": noteIDs,
+		},
+	}
+
+	var response notesInfoResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return nil, fmt.Errorf("fail to fetch notes info: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("AnkiConnect notes info error: %v", response.Error)
+	}
+
+	return response.Result, nil
+}
+
+func (anki *Anki) UpdateNoteFields(ctx context.Context, noteID int64, fields map[string]string) error {
+	request := AnkiConnectRequest{
+		Action:  "updateNoteFields",
+		Version: 6,
+		Params: map[string]interface{}{
+			"note": map[string]interface{}{
+				"id":     noteID,
+				"fields": fields,
+			},
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return fmt.Errorf("fail to update note fields: %v", err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("AnkiConnect update note error: %v", response.Error)
+	}
+
+	return nil
+}
+
+func PageTag(pageID string) string {
+	return notionTagPrefix + pageID
+}
+
+func (anki *Anki) firstNote(ctx context.Context, query string) (*AnkiNoteInfo, error) {
+	noteIDs, err := anki.FindNotes(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(noteIDs) == 0 {
+		return nil, nil
+	}
+	if len(noteIDs) > 1 {
+		anki.logger.Printf("Query %s matched %d notes, using note %d", query, len(noteIDs), noteIDs[0])
+	}
+	return anki.noteByID(ctx, noteIDs[0])
+}
+
+func (anki *Anki) noteByID(ctx context.Context, noteID int64) (*AnkiNoteInfo, error) {
+	notes, err := anki.NotesInfo(ctx, []int64{noteID})
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 || notes[0].NoteID == 0 {
+		return nil, nil
+	}
+	return &notes[0], nil
+}
+
+func (anki *Anki) findNoteByPrimaryKey(ctx context.Context, primaryKeyField string, fields map[string]string) (*AnkiNoteInfo, error) {
+	if primaryKeyField == "" {
+		fieldNames, err := anki.ModelFieldNames(ctx, anki.Config.ModelName)
+		if err != nil {
+			return nil, err
+		}
+		if len(fieldNames) == 0 {
+			return nil, fmt.Errorf("model %s has no fields", anki.Config.ModelName)
+		}
+		primaryKeyField = fieldNames[0]
+	}
+
+	value, ok := fields[primaryKeyField]
+	if !ok || value == "" {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf(`"note:%s" "%s:%s" -"tag:%s*"`,
+		escapeAnkiQuery(anki.Config.ModelName),
+		escapeAnkiQuery(primaryKeyField),
+		escapeAnkiQuery(value),
+		escapeAnkiQuery(notionTagPrefix))
+	return anki.firstNote(ctx, query)
+}
+
+func (anki *Anki) LookupNoteForPage(ctx context.Context, pageID string, knownNoteID int64, primaryKeyField string, fields map[string]string) (*AnkiNoteInfo, bool, error) {
+	if knownNoteID != 0 {
+		note, err := anki.noteByID(ctx, knownNoteID)
+		if err != nil || note != nil {
+			return note, false, err
+		}
+		anki.logger.Printf("Note %d mapped to page %s no longer exists in Anki", knownNoteID, pageID)
+	}
+
+	note, err := anki.firstNote(ctx, fmt.Sprintf(`"tag:%s"`, escapeAnkiQuery(PageTag(pageID))))
+	if err != nil || note != nil {
+		return note, false, err
+	}
+
+	note, err = anki.findNoteByPrimaryKey(ctx, primaryKeyField, fields)
+	return note, note != nil, err
+}
+
+func (anki *Anki) FindNoteForPage(ctx context.Context, pageID string, knownNoteID int64, primaryKeyField string, fields map[string]string) (*AnkiNoteInfo, error) {
+	note, adopted, err := anki.LookupNoteForPage(ctx, pageID, knownNoteID, primaryKeyField, fields)
+	if err != nil || note == nil {
+		return note, err
+	}
+	if adopted {
+		anki.logger.Printf("Adopting existing note %d for page %s", note.NoteID, pageID)
+	}
+	return note, anki.ensurePageTag(ctx, note, pageID)
+}
+
+func (anki *Anki) ensurePageTag(ctx context.Context, note *AnkiNoteInfo, pageID string) error {
+	tag := PageTag(pageID)
+	for _, existing := range note.Tags {
+		if existing == tag {
+			return nil
+		}
+	}
+	if err := anki.AddTags(ctx, []int64{note.NoteID}, tag); err != nil {
+		return err
+	}
+	note.Tags = append(note.Tags, tag)
+	return nil
+}
+
+func (anki *Anki) AddTags(ctx context.Context, noteIDs []int64, tags string) error {
+	request := AnkiConnectRequest{
+		Action:  "addTags",
+		Version: 6,
+		Params: map[string]interface{}{
+			"notes": noteIDs,
+			"tags":  tags,
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return fmt.Errorf("fail to add tags: %v", err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("AnkiConnect add tags error: %v", response.Error)
+	}
+
+	return nil
+}
+
+func changedFields(note *AnkiNoteInfo, fields map[string]string) map[string]string {
+	changed := map[string]string{}
+	for name, value := range fields {
+		current, exist := note.Fields[name]
+		if !exist {
+			continue
+		}
+		if current.Value != value {
+			changed[name] = value
+		}
+	}
+	return changed
+}
+
+func (anki *Anki) UpdateNote(ctx context.Context, note *AnkiNoteInfo, fields map[string]string) (bool, error) {
+	changed := changedFields(note, fields)
+	if len(changed) == 0 {
+		return false, nil
+	}
+
+	if err := anki.UpdateNoteFields(ctx, note.NoteID, changed); err != nil {
+		return false, err
+	}
+
+	anki.logger.Printf("Updated note %d fields: %v", note.NoteID, mapKeys(changed))
+	return true, nil
+}
+
+func escapeAnkiQuery(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`*`, `\*`,
+		`_`, `\_`,
+	)
+	return replacer.Replace(value)
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (anki *Anki) DeleteNotes(ctx context.Context, noteIDs []int64) error {
+	request := AnkiConnectRequest{
+		Action:  "deleteNotes",
+		Version: 6,
+		Params: map[string]interface{}{
+			"notes": noteIDs,
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return fmt.Errorf("fail to delete notes: %v", err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("AnkiConnect delete notes error: %v", response.Error)
+	}
+
+	return nil
+}
+
+func (anki *Anki) SuspendNotes(ctx context.Context, noteIDs []int64) error {
+	notes, err := anki.NotesInfo(ctx, noteIDs)
+	if err != nil {
+		return err
+	}
+
+	var cardIDs []int64
+	for _, note := range notes {
+		cardIDs = append(cardIDs, note.Cards...)
+	}
+	if len(cardIDs) == 0 {
+		return nil
+	}
+
+	request := AnkiConnectRequest{
+		Action:  "suspend",
+		Version: 6,
+		Params: map[string]interface{}{
+			"cards": cardIDs,
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return fmt.Errorf("fail to suspend cards: %v", err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("AnkiConnect suspend error: %v", response.Error)
+	}
+
+	return nil
+}
+
+type AnkiCardInfo struct {
+	CardID   int64  `json:"cardId"`
+	NoteID   int64  `json:"note"`
+	DeckName string `json:"deckName"`
+	Interval int    `json:"interval"`
+	Factor   int    `json:"factor"`
+	Lapses   int    `json:"lapses"`
+	Reps     int    `json:"reps"`
+	Type     int    `json:"type"`
+	Queue    int    `json:"queue"`
+	Due      int64  `json:"due"`
+}
+
+type AnkiReview struct {
+	ID       int64 `json:"id"`
+	Interval int   `json:"ivl"`
+}
+
+type cardsInfoResponse struct {
+	Result []AnkiCardInfo `json:"result"`
+	Error  interface{}    `json:"error"`
+}
+
+type reviewsOfCardsResponse struct {
+	Result map[string][]AnkiReview `json:"result"`
+	Error  interface{}             `json:"error"`
+}
+
+func (anki *Anki) CardsInfo(ctx context.Context, cardIDs []int64) ([]AnkiCardInfo, error) {
+	request := AnkiConnectRequest{
+		Action:  "cardsInfo",
+		Version: 6,
+		Params: map[string]interface{}{
+			"cards": cardIDs,
+		},
+	}
+
+	var response cardsInfoResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return nil, fmt.Errorf("fail to fetch cards info: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("AnkiConnect cards info error: %v", response.Error)
+	}
+
+	return response.Result, nil
+}
+
+func (anki *Anki) GetReviewsOfCards(ctx context.Context, cardIDs []int64) (map[int64][]AnkiReview, error) {
+	cards := make([]string, 0, len(cardIDs))
+	for _, cardID := range cardIDs {
+		cards = append(cards, strconv.FormatInt(cardID, 10))
+	}
+
+	request := AnkiConnectRequest{
+		Action:  "getReviewsOfCards",
+		Version: 6,
+		Params: map[string]interface{}{
+			"cards": cards,
+		},
+	}
+
+	var response reviewsOfCardsResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return nil, fmt.Errorf("fail to fetch card reviews: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("AnkiConnect card reviews error: %v", response.Error)
+	}
+
+	reviews := make(map[int64][]AnkiReview, len(response.Result))
+	for card, cardReviews := range response.Result {
+		cardID, err := strconv.ParseInt(card, 10, 64)
+		if err != nil {
+			continue
+		}
+		reviews[cardID] = cardReviews
+	}
+	return reviews, nil
+}
+
+func (anki *Anki) StoreMediaFile(ctx context.Context, filename, data string) (string, error) {
+	if anki.readOnly {
+		return filename, nil
+	}
+
+	request := AnkiConnectRequest{
+		Action:  "storeMediaFile",
+		Version: 6,
+		Params: map[string]interface{}{
+			"filename": filename,
+			"data":     data,
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return "", fmt.Errorf("fail to store media file: %v", err)
+	}
+
+	if response.Error != nil {
+		return "", fmt.Errorf("AnkiConnect store media error: %v", response.Error)
+	}
+
+	stored, ok := response.Result.(string)
+	if !ok || stored == "" {
+		stored = filename
+	}
+	return stored, nil
+}
+
+func (anki *Anki) MediaFileExists(ctx context.Context, filename string) (bool, error) {
+	request := AnkiConnectRequest{
+		Action:  "getMediaFilesNames",
+		Version: 6,
+		Params: map[string]interface{}{
+			"pattern": filename,
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return false, fmt.Errorf("fail to list media files: %v", err)
+	}
+
+	if response.Error != nil {
+		return false, fmt.Errorf("AnkiConnect list media error: %v", response.Error)
+	}
+
+	names, ok := response.Result.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("invalid response format: %v", response.Result)
+	}
+	for _, name := range names {
+		if name == filename {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+type modelTemplatesResponse struct {
+	Result map[string]map[string]string `json:"result"`
+	Error  interface{}                  `json:"error"`
+}
+
+func (anki *Anki) modelAction(ctx context.Context, action string, params map[string]interface{}) error {
+	if anki.readOnly {
+		anki.logger.Printf("Dry run: %s would be applied to model %s", action, anki.Config.ModelName)
+		return nil
+	}
+
+	request := AnkiConnectRequest{
+		Action:  action,
+		Version: 6,
+		Params:  params,
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return fmt.Errorf("fail to run %s: %v", action, err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("AnkiConnect %s error: %v", action, response.Error)
+	}
+
+	return nil
+}
+
+func (anki *Anki) ModelTemplates(ctx context.Context, modelName string) (map[string]map[string]string, error) {
+	request := AnkiConnectRequest{
+		Action:  "modelTemplates",
+		Version: 6,
+		Params: map[string]interface{}{
+			"modelName": modelName,
+		},
+	}
+
+	var response modelTemplatesResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return nil, fmt.Errorf("fail to fetch model templates: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("AnkiConnect model templates error: %v", response.Error)
+	}
+
+	return response.Result, nil
+}
+
+func (anki *Anki) reconcileModel(ctx context.Context, fields []string, renames map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	modelName := anki.Config.ModelName
+	existing, err := anki.ModelFieldNames(ctx, modelName)
+	if err != nil {
+		return err
+	}
+
+	var changes []string
+	for oldName, newName := range renames {
+		index := slices.Index(existing, oldName)
+		if index < 0 || slices.Contains(existing, newName) || !slices.Contains(fields, newName) {
+			continue
+		}
+		if err := anki.modelAction(ctx, "modelFieldRename", map[string]interface{}{
+			"modelName":    modelName,
+			"oldFieldName": oldName,
+			"newFieldName": newName,
+		}); err != nil {
+			return err
+		}
+		existing[index] = newName
+		changes = append(changes, fmt.Sprintf("~ %s -> %s", oldName, newName))
+		if anki.readOnly {
+			if anki.pendingRenames == nil {
+				anki.pendingRenames = map[string]string{}
+			}
+			anki.pendingRenames[newName] = oldName
+		}
+	}
+
+	for i, field := range fields {
+		if slices.Contains(existing, field) {
+			continue
+		}
+		index := min(i, len(existing))
+		if err := anki.modelAction(ctx, "modelFieldAdd", map[string]interface{}{
+			"modelName": modelName,
+			"fieldName": field,
+			"index":     index,
+		}); err != nil {
+			return err
+		}
+		existing = slices.Insert(existing, index, field)
+		changes = append(changes, "+ "+field)
+	}
+
+	for _, field := range existing {
+		if !slices.Contains(fields, field) {
+			anki.logger.Printf("Model %s field %s has no Notion source, keeping it", modelName, field)
+		}
+	}
+
+	if len(changes) > 0 {
+		if anki.readOnly {
+			anki.pendingFields = existing
+		}
+		anki.logger.Printf("Updated fields of model %s:", modelName)
+		for _, change := range changes {
+			anki.logger.Printf("  %s", change)
+		}
+		anki.templatesSynced = false
+	}
+
+	if anki.Config.Templates.UpdateExisting && !anki.templatesSynced {
+		if err := anki.updateModelTemplates(ctx, existing); err != nil {
+			return err
+		}
+		anki.templatesSynced = true
+	}
+
+	return nil
+}
+
+func (anki *Anki) updateModelTemplates(ctx context.Context, fields []string) error {
+	modelName := anki.Config.ModelName
+	templates, css, err := anki.Config.Templates.Build(fields)
+	if err != nil {
+		return fmt.Errorf("fail to build card templates: %v", err)
+	}
+
+	current, err := anki.ModelTemplates(ctx, modelName)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	for _, template := range templates {
+		if _, exist := current[template.Name]; !exist {
+			if err := anki.modelAction(ctx, "modelTemplateAdd", map[string]interface{}{
+				"modelName": modelName,
+				"template":  template,
+			}); err != nil {
+				return err
+			}
+			anki.logger.Printf("Added card template %s to model %s", template.Name, modelName)
+			continue
+		}
+		if current[template.Name]["Front"] == template.Front && current[template.Name]["Back"] == template.Back {
+			continue
+		}
+		updates[template.Name] = map[string]string{
+			"Front": template.Front,
+			"Back":  template.Back,
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := anki.modelAction(ctx, "updateModelTemplates", map[string]interface{}{
+			"model": map[string]interface{}{
+				"name":      modelName,
+				"templates": updates,
+			},
+		}); err != nil {
+			return err
+		}
+		anki.logger.Printf("Updated %d card templates of model %s", len(updates), modelName)
+	}
+
+	return anki.modelAction(ctx, "updateModelStyling", map[string]interface{}{
+		"model": map[string]interface{}{
+			"name": modelName,
+			"css":  css,
+		},
+	})
+}
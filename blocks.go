@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+type PageContentConfig struct {
+	Enabled     bool
+	TargetField string
+	MaxDepth    int
+}
+
+type blockRenderer struct {
+	imageSource func(src string) string
+}
+
+type blockNode struct {
+	Block    notion.Block
+	Children []blockNode
+}
+
+func (nt *NotionClient) FetchBlockTree(ctx context.Context, blockID string, maxDepth int) ([]blockNode, error) {
+	var nodes []blockNode
+	var cursor string
+
+	for {
+		result, err := nt.Client.FindBlockChildrenByID(ctx, blockID, &notion.PaginationQuery{
+			StartCursor: cursor,
+			PageSize:    100,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch block children of %s: %v", blockID, err)
+		}
+
+		for _, block := range result.Results {
+			node := blockNode{Block: block}
+			if block.HasChildren() && maxDepth > 0 && !isChildPage(block) {
+				children, err := nt.FetchBlockTree(ctx, block.ID(), maxDepth-1)
+				if err != nil {
+					return nil, err
+				}
+				node.Children = children
+			}
+			nodes = append(nodes, node)
+		}
+
+		if !result.HasMore || result.NextCursor == nil {
+			break
+		}
+		cursor = *result.NextCursor
+	}
+
+	return nodes, nil
+}
+
+func isChildPage(block notion.Block) bool {
+	switch block.(type) {
+	case *notion.ChildPageBlock, *notion.ChildDatabaseBlock:
+		return true
+	}
+	return false
+}
+
+func (nt *NotionClient) RenderPageContent(ctx context.Context, pageID string, maxDepth int, media *MediaPipeline) (string, error) {
+	nodes, err := nt.FetchBlockTree(ctx, pageID, maxDepth)
+	if err != nil {
+		return "", err
+	}
+
+	renderer := blockRenderer{}
+	var mediaErr error
+	if media != nil {
+		renderer.imageSource = func(src string) string {
+			filename, err := media.Store(ctx, src, "")
+			if errors.Is(err, errMediaNotStored) {
+				return src
+			}
+			if err != nil {
+				if mediaErr == nil {
+					mediaErr = fmt.Errorf("fail to store image: %v", err)
+				}
+				return src
+			}
+			return filename
+		}
+	}
+	content := renderer.renderBlocks(nodes)
+	if mediaErr != nil {
+		return "", mediaErr
+	}
+	return content, nil
+}
+
+func (r blockRenderer) renderBlocks(nodes []blockNode) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(nodes); i++ {
+		switch nodes[i].Block.(type) {
+		case *notion.BulletedListItemBlock, *notion.NumberedListItemBlock:
+			tag := listTag(nodes[i].Block)
+			builder.WriteString("<" + tag + ">")
+			for ; i < len(nodes) && listTag(nodes[i].Block) == tag; i++ {
+				builder.WriteString(r.renderBlock(nodes[i]))
+			}
+			builder.WriteString("</" + tag + ">")
+			i--
+		default:
+			builder.WriteString(r.renderBlock(nodes[i]))
+		}
+	}
+
+	return builder.String()
+}
+
+func listTag(block notion.Block) string {
+	switch block.(type) {
+	case *notion.BulletedListItemBlock:
+		return "ul"
+	case *notion.NumberedListItemBlock:
+		return "ol"
+	}
+	return ""
+}
+
+func (r blockRenderer) renderBlock(node blockNode) string {
+	children := r.renderBlocks(node.Children)
+
+	switch block := node.Block.(type) {
+	case *notion.ParagraphBlock:
+		return colorize("<p>"+renderRichText(block.RichText)+"</p>", block.Color) + children
+	case *notion.Heading1Block:
+		return "<h1>" + renderRichText(block.RichText) + "</h1>" + children
+	case *notion.Heading2Block:
+		return "<h2>" + renderRichText(block.RichText) + "</h2>" + children
+	case *notion.Heading3Block:
+		return "<h3>" + renderRichText(block.RichText) + "</h3>" + children
+	case *notion.BulletedListItemBlock:
+		return "<li>" + renderRichText(block.RichText) + children + "</li>"
+	case *notion.NumberedListItemBlock:
+		return "<li>" + renderRichText(block.RichText) + children + "</li>"
+	case *notion.ToDoBlock:
+		box := "☐"
+		if block.Checked != nil && *block.Checked {
+			box = "☑"
+		}
+		return "<div>" + box + " " + renderRichText(block.RichText) + "</div>" + children
+	case *notion.ToggleBlock:
+		return "<details><summary>" + renderRichText(block.RichText) + "</summary>" + children + "</details>"
+	case *notion.QuoteBlock:
+		return "<blockquote>" + renderRichText(block.RichText) + children + "</blockquote>"
+	case *notion.CalloutBlock:
+		icon := ""
+		if block.Icon != nil && block.Icon.Emoji != nil {
+			icon = *block.Icon.Emoji + " "
+		}
+		return colorize(`<div class="callout">`+icon+renderRichText(block.RichText)+children+"</div>", block.Color)
+	case *notion.CodeBlock:
+		return "<pre><code>" + strings.ReplaceAll(html.EscapeString(plainText(block.RichText)), "\n", "<br>") + "</code></pre>"
+	case *notion.EquationBlock:
+		return `<div>\[` + html.EscapeString(block.Expression) + `\]</div>`
+	case *notion.DividerBlock:
+		return "<hr>"
+	case *notion.TableBlock:
+		return renderTable(block, node.Children)
+	case *notion.ColumnListBlock:
+		return `<div class="columns">` + children + "</div>"
+	case *notion.ColumnBlock:
+		return `<div class="column">` + children + "</div>"
+	case *notion.SyncedBlock, *notion.TemplateBlock:
+		return children
+	case *notion.ImageBlock:
+		src := ""
+		switch {
+		case block.File != nil:
+			src = block.File.URL
+		case block.External != nil:
+			src = block.External.URL
+		}
+		if src == "" {
+			return ""
+		}
+		if r.imageSource != nil {
+			src = r.imageSource(src)
+		}
+		return fmt.Sprintf(`<img src="%s">`, html.EscapeString(src))
+	case *notion.BookmarkBlock:
+		return renderLink(block.URL)
+	case *notion.EmbedBlock:
+		return renderLink(block.URL)
+	case *notion.LinkPreviewBlock:
+		return renderLink(block.URL)
+	case *notion.ChildPageBlock:
+		return "<p>" + html.EscapeString(block.Title) + "</p>"
+	}
+
+	return children
+}
+
+func renderTable(table *notion.TableBlock, rows []blockNode) string {
+	var builder strings.Builder
+	builder.WriteString("<table>")
+	for i, row := range rows {
+		tableRow, ok := row.Block.(*notion.TableRowBlock)
+		if !ok {
+			continue
+		}
+		builder.WriteString("<tr>")
+		for j, cell := range tableRow.Cells {
+			tag := "td"
+			if (i == 0 && table.HasColumnHeader) || (j == 0 && table.HasRowHeader) {
+				tag = "th"
+			}
+			builder.WriteString("<" + tag + ">" + renderRichText(cell) + "</" + tag + ">")
+		}
+		builder.WriteString("</tr>")
+	}
+	builder.WriteString("</table>")
+	return builder.String()
+}
+
+func renderLink(url string) string {
+	if url == "" {
+		return ""
+	}
+	escaped := html.EscapeString(url)
+	return fmt.Sprintf(`<p><a href="%s">%s</a></p>`, escaped, escaped)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notion2anki/processors"
+)
+
+type ProcessorCacheConfig struct {
+	Enabled     bool
+	Path        string
+	TTL         time.Duration
+	NegativeTTL time.Duration
+}
+
+type cacheEntry struct {
+	Value    string    `json:"value,omitempty"`
+	Found    bool      `json:"found"`
+	StoredAt time.Time `json:"stored_at"`
+}
+
+type cacheFile struct {
+	Processors map[string]map[string]cacheEntry `json:"processors"`
+}
+
+type ProcessorCache struct {
+	cfg      ProcessorCacheConfig
+	mu       sync.Mutex
+	entries  map[string]map[string]cacheEntry
+	pending  map[string]map[string]cacheEntry
+	modTime  time.Time
+	readOnly bool
+}
+
+func NewProcessorCache(cfg ProcessorCacheConfig) (*ProcessorCache, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("processor_cache.path is required")
+	}
+	if cfg.TTL < 0 || cfg.NegativeTTL < 0 {
+		return nil, errors.New("processor_cache TTLs must not be negative")
+	}
+
+	cache := &ProcessorCache{
+		cfg:     cfg,
+		entries: map[string]map[string]cacheEntry{},
+		pending: map[string]map[string]cacheEntry{},
+	}
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// load reads the cache file again when it changed since it was last read,
+// so a purge from another process also reaches a running daemon. Entries
+// not flushed yet are kept on top of the file.
+func (c *ProcessorCache) load() error {
+	info, err := os.Stat(c.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		if !c.modTime.IsZero() {
+			c.entries = map[string]map[string]cacheEntry{}
+			c.mergePending()
+			c.modTime = time.Time{}
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("fail to read processor cache: %v", err)
+	}
+	if info.ModTime().Equal(c.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.cfg.Path)
+	if err != nil {
+		return fmt.Errorf("fail to read processor cache: %v", err)
+	}
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("fail to parse processor cache %s: %v", c.cfg.Path, err)
+	}
+	c.entries = file.Processors
+	if c.entries == nil {
+		c.entries = map[string]map[string]cacheEntry{}
+	}
+	c.mergePending()
+	c.modTime = info.ModTime()
+	return nil
+}
+
+func (c *ProcessorCache) mergePending() {
+	for processor, entries := range c.pending {
+		if c.entries[processor] == nil {
+			c.entries[processor] = map[string]cacheEntry{}
+		}
+		for key, entry := range entries {
+			c.entries[processor][key] = entry
+		}
+	}
+}
+
+func normalizeCacheKey(input string) string {
+	return strings.ToLower(strings.Join(strings.Fields(input), " "))
+}
+
+func (c *ProcessorCache) expired(entry cacheEntry, now time.Time) bool {
+	ttl := c.cfg.TTL
+	if !entry.Found {
+		ttl = c.cfg.NegativeTTL
+	}
+	return ttl > 0 && now.Sub(entry.StoredAt) > ttl
+}
+
+func (c *ProcessorCache) Get(processor, input string) (processors.CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		log.Printf("Failed to reload processor cache: %v", err)
+	}
+	entry, exist := c.entries[processor][normalizeCacheKey(input)]
+	if !exist || c.expired(entry, time.Now()) {
+		return processors.CacheEntry{}, false
+	}
+	return processors.CacheEntry{Value: entry.Value, Found: entry.Found}, true
+}
+
+func (c *ProcessorCache) Put(processor, input string, entry processors.CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := normalizeCacheKey(input)
+	stored := cacheEntry{Value: entry.Value, Found: entry.Found, StoredAt: time.Now()}
+	for _, entries := range []map[string]map[string]cacheEntry{c.entries, c.pending} {
+		if entries[processor] == nil {
+			entries[processor] = map[string]cacheEntry{}
+		}
+		entries[processor][key] = stored
+	}
+	return nil
+}
+
+// Flush merges the entries added since the last flush into the cache file,
+// keeping whatever other processes purged from it. Entries of a read-only
+// cache are only kept in memory.
+func (c *ProcessorCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) == 0 || c.readOnly {
+		return nil
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+	return c.save()
+}
+
+type cacheListing struct {
+	Processor string
+	Key       string
+	Entry     cacheEntry
+	Expired   bool
+}
+
+func (c *ProcessorCache) List(processor string) []cacheListing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var listings []cacheListing
+	for name, entries := range c.entries {
+		if processor != "" && name != processor {
+			continue
+		}
+		for key, entry := range entries {
+			listings = append(listings, cacheListing{
+				Processor: name,
+				Key:       key,
+				Entry:     entry,
+				Expired:   c.expired(entry, now),
+			})
+		}
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Processor != listings[j].Processor {
+			return listings[i].Processor < listings[j].Processor
+		}
+		return listings[i].Key < listings[j].Key
+	})
+	return listings
+}
+
+type cachePurge struct {
+	Processor string
+	Expired   bool
+	Negative  bool
+}
+
+func (c *ProcessorCache) Purge(purge cachePurge) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	removed := 0
+	for processor, entries := range c.entries {
+		if purge.Processor != "" && processor != purge.Processor {
+			continue
+		}
+		for key, entry := range entries {
+			if purge.Expired && !c.expired(entry, now) {
+				continue
+			}
+			if purge.Negative && entry.Found {
+				continue
+			}
+			delete(entries, key)
+			delete(c.pending[processor], key)
+			removed++
+		}
+		if len(entries) == 0 {
+			delete(c.entries, processor)
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := c.save(); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (c *ProcessorCache) save() error {
+	data, err := json.MarshalIndent(cacheFile{Processors: c.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("fail to serialize processor cache: %v", err)
+	}
+	if err := writeFileAtomic(c.cfg.Path, data); err != nil {
+		return fmt.Errorf("fail to write processor cache: %v", err)
+	}
+	c.pending = map[string]map[string]cacheEntry{}
+	if info, err := os.Stat(c.cfg.Path); err == nil {
+		c.modTime = info.ModTime()
+	}
+	return nil
+}
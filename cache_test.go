@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/notion2anki/processors"
+)
+
+func TestProcessorCachePurgeBetweenFlushes(t *testing.T) {
+	cfg := ProcessorCacheConfig{
+		Enabled:     true,
+		Path:        filepath.Join(t.TempDir(), "processor_cache.json"),
+		TTL:         time.Hour,
+		NegativeTTL: time.Hour,
+	}
+
+	daemon, err := NewProcessorCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon.Put("dwds_audio", "Haus", processors.CacheEntry{Value: "https://example.com/haus.mp3", Found: true})
+	daemon.Put("dwds_audio", "Xyz", processors.CacheEntry{Found: false})
+	if err := daemon.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	cli, err := NewProcessorCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := cli.Purge(cachePurge{Negative: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("removed %d entries, want 1", removed)
+	}
+
+	if _, exist := daemon.Get("dwds_audio", "xyz"); exist {
+		t.Fatal("daemon still serves the purged entry")
+	}
+	daemon.Put("dwds_audio", "Baum", processors.CacheEntry{Value: "https://example.com/baum.mp3", Found: true})
+	if err := daemon.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewProcessorCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := reloaded.Get("dwds_audio", "xyz"); exist {
+		t.Fatal("flush wrote the purged entry back")
+	}
+	for _, word := range []string{"haus", "baum"} {
+		if _, exist := reloaded.Get("dwds_audio", word); !exist {
+			t.Fatalf("entry %q is missing after the flush", word)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/notion2anki/processors"
+)
+
+type cliCommand struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{"daemon", "Sync on every poll interval until stopped (default)", runDaemonCommand},
+		{"sync", "Sync the selected jobs; with --once, sync once and exit; with --dry-run, print what would change", runSyncCommand},
+		{"full-resync", "Sync every page again, ignoring the last sync time, and exit", runFullResyncCommand},
+		{"status", "Show the stored sync state of each job", runStatusCommand},
+		{"validate-config", "Check the configuration and the Notion database schema", runValidateConfigCommand},
+		{"diff", "Show how Anki differs from Notion without changing anything", runDiffCommand},
+		{"processors", "Manage processors: processors list", runProcessorsCommand},
+		{"cache", "Inspect or clear the processor cache: cache list, cache purge", runCacheCommand},
+	}
+}
+
+type commonFlags struct {
+	configPath string
+	jobs       string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	common := &commonFlags{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&common.configPath, "config", "", "path to the config file (default ./config.yaml)")
+	fs.StringVar(&common.jobs, "job", "", "comma-separated names of the jobs to run (default all)")
+	return fs, common
+}
+
+func runCLI(ctx context.Context, args []string) error {
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+		printUsage()
+		return nil
+	}
+
+	name, commandArgs := "daemon", args
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, commandArgs = args[0], args[1:]
+	}
+	for _, command := range cliCommands {
+		if command.name == name {
+			err := command.run(ctx, commandArgs)
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	printUsage()
+	return fmt.Errorf("unknown command: %s", name)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: notion2anki [command] [--config path] [--job name,...]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, command := range cliCommands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", command.name, command.usage)
+	}
+}
+
+func loadSelectedJobs(common *commonFlags) (*Config, []*JobConfig, error) {
+	cfg, err := loadConfig(common.configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading configuration: %v", err)
+	}
+	if common.jobs == "" {
+		return cfg, cfg.Jobs, nil
+	}
+
+	var selected []*JobConfig
+	for _, name := range strings.Split(common.jobs, ",") {
+		name = strings.TrimSpace(name)
+		index := slices.IndexFunc(cfg.Jobs, func(job *JobConfig) bool {
+			return job.Name == name
+		})
+		if index < 0 {
+			return nil, nil, fmt.Errorf("unknown job: %s", name)
+		}
+		selected = append(selected, cfg.Jobs[index])
+	}
+	return cfg, selected, nil
+}
+
+func setupJobs(common *commonFlags) ([]*SyncJob, StateStore, error) {
+	cfg, jobConfigs, err := loadSelectedJobs(common)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := NewStateStore(cfg.State)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating state store: %v", err)
+	}
+
+	var jobs []*SyncJob
+	for _, jobConfig := range jobConfigs {
+		job, err := NewSyncJob(jobConfig, store)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error setting up sync job %s: %v", jobConfig.DisplayName(), err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	var cache *ProcessorCache
+	if cfg.ProcessorCache.Enabled {
+		if cache, err = NewProcessorCache(cfg.ProcessorCache); err != nil {
+			return nil, nil, fmt.Errorf("error loading processor cache: %v", err)
+		}
+	}
+
+	for _, processor := range processorRegistry {
+		if setter, ok := processor.(processors.CacheSetter); ok && cache != nil {
+			setter.SetCache(cache)
+		}
+	}
+	for _, job := range jobs {
+		job.Cache = cache
+	}
+
+	return jobs, store, nil
+}
+
+func runOnce(ctx context.Context, jobs []*SyncJob, store StateStore) error {
+	failed := 0
+	for _, job := range jobs {
+		if err := runSync(ctx, job, store); err != nil {
+			job.Logger.Printf("fail to sync: %v", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed to sync", failed, len(jobs))
+	}
+	return nil
+}
+
+func runDaemonCommand(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("daemon")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	jobs, store, err := setupJobs(common)
+	if err != nil {
+		return err
+	}
+	Start(ctx, jobs, store)
+	return nil
+}
+
+func runSyncCommand(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("sync")
+	once := fs.Bool("once", false, "sync once and exit instead of polling")
+	dryRun := fs.Bool("dry-run", false, "sync once without changing Anki or Notion and print the plan")
+	jsonOutput := fs.Bool("json", false, "print the dry-run plan as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	jobs, store, err := setupJobs(common)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return runDryRun(ctx, jobs, store, *jsonOutput)
+	}
+	if !*once {
+		Start(ctx, jobs, store)
+		return nil
+	}
+	return runOnce(ctx, jobs, store)
+}
+
+func runDryRun(ctx context.Context, jobs []*SyncJob, store StateStore, jsonOutput bool) error {
+	plans := make([]*SyncPlan, 0, len(jobs))
+	for _, job := range jobs {
+		job.EnableDryRun()
+		plans = append(plans, job.Plan)
+	}
+	syncErr := runOnce(ctx, jobs, store)
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plans); err != nil {
+			return err
+		}
+		return syncErr
+	}
+
+	for i, plan := range plans {
+		if i > 0 {
+			fmt.Println()
+		}
+		plan.Print(os.Stdout)
+	}
+	return syncErr
+}
+
+func runFullResyncCommand(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("full-resync")
+	dryRun := fs.Bool("dry-run", false, "resync without changing Anki or Notion and print the plan")
+	jsonOutput := fs.Bool("json", false, "print the dry-run plan as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	jobs, store, err := setupJobs(common)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		job.Notion.LastSyncTime = time.Time{}
+	}
+	if *dryRun {
+		return runDryRun(ctx, jobs, store, *jsonOutput)
+	}
+	return runOnce(ctx, jobs, store)
+}
+
+func runStatusCommand(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, jobConfigs, err := loadSelectedJobs(common)
+	if err != nil {
+		return err
+	}
+	store, err := NewStateStore(cfg.State)
+	if err != nil {
+		return fmt.Errorf("error creating state store: %v", err)
+	}
+
+	for i, jobConfig := range jobConfigs {
+		state, err := store.Load(jobConfig.StateKey)
+		if err != nil {
+			return fmt.Errorf("error loading sync state: %v", err)
+		}
+
+		lastSync := "never"
+		if !state.LastSyncTime.IsZero() {
+			lastSync = state.LastSyncTime.Local().Format(time.RFC3339)
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s\n", jobConfig.DisplayName())
+		fmt.Printf("  database:   %s\n", jobConfig.NotionDatabaseID)
+		fmt.Printf("  deck:       %s\n", jobConfig.DeckName)
+		fmt.Printf("  note type:  %s\n", jobConfig.ModelName)
+		fmt.Printf("  last sync:  %s\n", lastSync)
+		fmt.Printf("  notes:      %d\n", len(state.PageNotes))
+		if len(state.Media) > 0 {
+			fmt.Printf("  media:      %d\n", len(state.Media))
+		}
+	}
+	return nil
+}
+
+func runValidateConfigCommand(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("validate-config")
+	offline := fs.Bool("offline", false, "skip checking the Notion database schema")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, jobConfigs, err := loadSelectedJobs(common)
+	if err != nil {
+		return err
+	}
+	if _, err := NewStateStore(cfg.State); err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, jobConfig := range jobConfigs {
+		var issues []string
+		for _, processConfig := range jobConfig.Processors {
+			if _, exist := processorRegistry[processConfig.Name]; !exist {
+				issues = append(issues, fmt.Sprintf("Processor %s is not registered", processConfig.Name))
+			}
+		}
+		if _, _, err := jobConfig.CardTemplates.Build([]string{"Front", "Back"}); err != nil {
+			issues = append(issues, err.Error())
+		}
+
+		if !*offline {
+			nt := NewNotion(jobConfig.NotionToken, jobConfig.NotionDatabaseID)
+			if nt == nil {
+				issues = append(issues, "Failed to create Notion client")
+			} else if schema, _, err := nt.DatabaseSchema(ctx); err != nil {
+				issues = append(issues, err.Error())
+			} else {
+				issues = append(issues, schemaWarnings(jobConfig, schema)...)
+			}
+		}
+
+		if len(issues) == 0 {
+			fmt.Printf("✅ %s: ok\n", jobConfig.DisplayName())
+			continue
+		}
+		fmt.Printf("❌ %s:\n", jobConfig.DisplayName())
+		for _, issue := range issues {
+			fmt.Printf("  - %s\n", issue)
+		}
+		problems += len(issues)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problems in the configuration", problems)
+	}
+	return nil
+}
+
+func runProcessorsCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("usage: notion2anki processors list [--config path] [--job name,...]")
+	}
+	fs, common := newFlagSet("processors list")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	_, jobConfigs, err := loadSelectedJobs(common)
+	if err != nil {
+		return err
+	}
+	enabledIn := map[string][]string{}
+	for _, jobConfig := range jobConfigs {
+		for _, processConfig := range jobConfig.Processors {
+			if processConfig.Enabled {
+				enabledIn[processConfig.Name] = append(enabledIn[processConfig.Name], jobConfig.DisplayName())
+			}
+		}
+	}
+
+	names := make([]string, 0, len(processorRegistry))
+	for name := range processorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if jobs := enabledIn[name]; len(jobs) > 0 {
+			fmt.Printf("%-16s enabled in: %s\n", name, strings.Join(jobs, ", "))
+		} else {
+			fmt.Printf("%-16s disabled\n", name)
+		}
+	}
+	return nil
+}
+
+func runCacheCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "purge") {
+		return fmt.Errorf("usage: notion2anki cache list|purge [--processor name] [--expired] [--negative] [--config path]")
+	}
+	fs, common := newFlagSet("cache " + args[0])
+	processor := fs.String("processor", "", "only entries of this processor")
+	expired := fs.Bool("expired", false, "only expired entries")
+	negative := fs.Bool("negative", false, "only cached \"not found\" results")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(common.configPath)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %v", err)
+	}
+	cache, err := NewProcessorCache(cfg.ProcessorCache)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "purge" {
+		removed, err := cache.Purge(cachePurge{Processor: *processor, Expired: *expired, Negative: *negative})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d cache entries\n", removed)
+		return nil
+	}
+
+	found, missing, stale := 0, 0, 0
+	for _, listing := range cache.List(*processor) {
+		if (*expired && !listing.Expired) || (*negative && listing.Entry.Found) {
+			continue
+		}
+		value := listing.Entry.Value
+		if listing.Entry.Found {
+			found++
+		} else {
+			value = "(not found)"
+			missing++
+		}
+		age := time.Since(listing.Entry.StoredAt).Round(time.Minute).String()
+		if listing.Expired {
+			age += ", expired"
+			stale++
+		}
+		fmt.Printf("%-16s %-24s %s (%s)\n", listing.Processor, listing.Key, value, age)
+	}
+	fmt.Printf("%d entries: %d found, %d not found, %d expired\n", found+missing, found, missing, stale)
+	return nil
+}
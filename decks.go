@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+type DeckRoute struct {
+	Value string `mapstructure:"value"`
+	Deck  string `mapstructure:"deck"`
+}
+
+type DeckRoutingConfig struct {
+	Template string      `mapstructure:"template"`
+	Property string      `mapstructure:"property"`
+	Routes   []DeckRoute `mapstructure:"routes"`
+}
+
+type DeckRouter struct {
+	template    *template.Template
+	property    string
+	routes      map[string]string
+	defaultDeck string
+	emptyValue  string
+}
+
+func NewDeckRouter(cfg DeckRoutingConfig, defaultDeck, emptyValue string) (*DeckRouter, error) {
+	router := &DeckRouter{
+		property:    cfg.Property,
+		routes:      map[string]string{},
+		defaultDeck: defaultDeck,
+		emptyValue:  emptyValue,
+	}
+
+	if cfg.Template != "" && cfg.Property != "" {
+		return nil, fmt.Errorf("deck_routing needs either template or property, not both")
+	}
+	if cfg.Property == "" && len(cfg.Routes) > 0 {
+		return nil, fmt.Errorf("deck_routing.property is required when routes are set")
+	}
+
+	if cfg.Template != "" {
+		tmpl, err := template.New("deck").Funcs(templateFuncs).Option("missingkey=zero").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deck_routing.template: %v", err)
+		}
+		router.template = tmpl
+	}
+
+	for i, route := range cfg.Routes {
+		if route.Value == "" || route.Deck == "" {
+			return nil, fmt.Errorf("deck_routing.routes[%d]: value and deck are required", i)
+		}
+		router.routes[route.Value] = route.Deck
+	}
+
+	return router, nil
+}
+
+func (r *DeckRouter) Enabled() bool {
+	return r.template != nil || r.property != ""
+}
+
+func (r *DeckRouter) Deck(properties map[string]string) (string, error) {
+	switch {
+	case r.template != nil:
+		var buf bytes.Buffer
+		if err := r.template.Execute(&buf, properties); err != nil {
+			return "", fmt.Errorf("failed to render deck name: %v", err)
+		}
+		deck := buf.String()
+		for _, part := range strings.Split(deck, "::") {
+			part = strings.TrimSpace(part)
+			if part == "" || part == r.emptyValue {
+				return r.defaultDeck, nil
+			}
+		}
+		return deck, nil
+	case r.property != "":
+		if deck, exist := r.routes[properties[r.property]]; exist {
+			return deck, nil
+		}
+	}
+	return r.defaultDeck, nil
+}
+
+func (anki *Anki) ChangeDeck(ctx context.Context, cardIDs []int64, deckName string) error {
+	request := AnkiConnectRequest{
+		Action:  "changeDeck",
+		Version: 6,
+		Params: map[string]interface{}{
+			"cards": cardIDs,
+			"deck":  deckName,
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return fmt.Errorf("fail to change deck: %v", err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("AnkiConnect change deck error: %v", response.Error)
+	}
+
+	return nil
+}
+
+func (anki *Anki) MisplacedCards(ctx context.Context, cardIDs []int64, deckName string) ([]int64, error) {
+	if len(cardIDs) == 0 {
+		return nil, nil
+	}
+
+	cards, err := anki.CardsInfo(ctx, cardIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var misplaced []int64
+	for _, card := range cards {
+		if card.DeckName != deckName {
+			misplaced = append(misplaced, card.CardID)
+		}
+	}
+	return misplaced, nil
+}
+
+func (anki *Anki) MoveCards(ctx context.Context, cardIDs []int64, deckName string) (int, error) {
+	misplaced, err := anki.MisplacedCards(ctx, cardIDs, deckName)
+	if err != nil || len(misplaced) == 0 {
+		return 0, err
+	}
+
+	if err := anki.EnsureDeck(ctx, deckName); err != nil {
+		return 0, err
+	}
+	if err := anki.ChangeDeck(ctx, misplaced, deckName); err != nil {
+		return 0, err
+	}
+	return len(misplaced), nil
+}
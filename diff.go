@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type noteDiff struct {
+	PageID string
+	NoteID int64
+	Title  string
+	Fields []string
+}
+
+type jobDiff struct {
+	Added   []noteDiff
+	Updated []noteDiff
+	Removed []noteDiff
+}
+
+func diffJob(ctx context.Context, job *SyncJob) (*jobDiff, error) {
+	anki, nt, cfg, state := job.Anki, job.Notion, job.Config, job.State
+
+	if err := anki.CheckAnkiConnect(ctx); err != nil {
+		return nil, err
+	}
+
+	// Processors run like in a dry run, so the fields they fill are not
+	// reported as changes, but nothing is written or cached.
+	job.EnableDryRun()
+	schema, _, err := nt.DatabaseSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nt.LastSyncTime = time.Time{}
+	pages, err := nt.QueryAllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var media *MediaPipeline
+	if cfg.Media.Enabled {
+		media = NewMediaPipeline(anki, cfg.Media, state)
+		media.offline = true
+	}
+
+	diff := &jobDiff{}
+	livePages := map[string]bool{}
+	for _, page := range pages {
+		if page.Archived {
+			continue
+		}
+		livePages[page.ID] = true
+
+		properties, plain, err := job.pageProperties(ctx, page, media)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch content of page %s: %v", page.ID, err)
+		}
+		page, _ = job.runProcessors(ctx, page, properties, plain, schema)
+		note, err := job.buildNote(page, properties, plain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build note for page %s: %v", page.ID, err)
+		}
+		title := pageTitle(page)
+
+		existingNote, _, err := anki.LookupNoteForPage(ctx, page.ID, state.PageNotes[page.ID], cfg.PrimaryKeyField, note.PlainFields)
+		if err != nil {
+			return nil, err
+		}
+		if existingNote == nil {
+			diff.Added = append(diff.Added, noteDiff{PageID: page.ID, Title: title})
+			continue
+		}
+
+		if changed := changedFields(existingNote, note.Fields); len(changed) > 0 {
+			fields := mapKeys(changed)
+			sort.Strings(fields)
+			diff.Updated = append(diff.Updated, noteDiff{PageID: page.ID, NoteID: existingNote.NoteID, Title: title, Fields: fields})
+		}
+	}
+
+	for pageID, noteID := range state.PageNotes {
+		if !livePages[pageID] {
+			diff.Removed = append(diff.Removed, noteDiff{PageID: pageID, NoteID: noteID})
+		}
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].NoteID < diff.Removed[j].NoteID
+	})
+
+	return diff, nil
+}
+
+func runDiffCommand(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("diff")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	jobs, _, err := setupJobs(common)
+	if err != nil {
+		return err
+	}
+
+	for i, job := range jobs {
+		diff, err := diffJob(ctx, job)
+		if err != nil {
+			return fmt.Errorf("job %s: %v", job.Config.DisplayName(), err)
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s: %d to add, %d to update, %d removed from Notion\n",
+			job.Config.DisplayName(), len(diff.Added), len(diff.Updated), len(diff.Removed))
+		for _, note := range diff.Added {
+			fmt.Printf("  + %s (page %s)\n", note.Title, note.PageID)
+		}
+		for _, note := range diff.Updated {
+			fmt.Printf("  ~ %s (note %d): %v\n", note.Title, note.NoteID, note.Fields)
+		}
+		for _, note := range diff.Removed {
+			fmt.Printf("  - note %d (page %s)\n", note.NoteID, note.PageID)
+		}
+	}
+	return nil
+}
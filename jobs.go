@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/notion2anki/processors"
+)
+
+type SyncJob struct {
+	Config *JobConfig
+	Anki   *Anki
+	Notion *NotionClient
+	State  *SyncState
+	Logger *log.Logger
+	Plan   *SyncPlan
+	Cache  *ProcessorCache
+}
+
+func (cfg *JobConfig) DisplayName() string {
+	if cfg.Name == "" {
+		return "default"
+	}
+	return cfg.Name
+}
+
+func NewSyncJob(cfg *JobConfig, store StateStore) (*SyncJob, error) {
+	logger := log.Default()
+	if cfg.Name != "" {
+		logger = log.New(log.Writer(), "["+cfg.Name+"] ", log.Flags()|log.Lmsgprefix)
+	}
+
+	anki := NewAnki(cfg.AnkiConnectURL, cfg.DeckName, cfg.ModelName)
+	anki.Config.Templates = cfg.CardTemplates
+	anki.logger = logger
+
+	nt := NewNotion(cfg.NotionToken, cfg.NotionDatabaseID)
+	if nt == nil {
+		return nil, fmt.Errorf("failed to create Notion client")
+	}
+	nt.Format = cfg.PropertyFormat
+	nt.logger = logger
+
+	state, err := store.Load(cfg.StateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync state: %v", err)
+	}
+	nt.LastSyncTime = state.LastSyncTime
+	if state.LastSyncTime.IsZero() {
+		logger.Println("No previous sync state found, running a full sync")
+	} else {
+		logger.Printf("Resuming from last sync at %s", state.LastSyncTime.Format(time.RFC3339))
+	}
+
+	return &SyncJob{
+		Config: cfg,
+		Anki:   anki,
+		Notion: nt,
+		State:  state,
+		Logger: logger,
+	}, nil
+}
+
+// pageProperties returns the property values for the Anki fields and the
+// same values as plain text, which processors, deck routing and note lookups
+// work with.
+func (job *SyncJob) pageProperties(ctx context.Context, page notion.Page, media *MediaPipeline) (map[string]string, map[string]string, error) {
+	properties := job.Notion.ExtractPropertiesFromPage(page)
+
+	if media != nil {
+		if err := media.ReplaceFileProperties(ctx, page, properties); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if job.Config.PageContent.Enabled {
+		content, err := job.Notion.RenderPageContent(ctx, page.ID, job.Config.PageContent.MaxDepth, media)
+		if err != nil {
+			return nil, nil, err
+		}
+		properties[job.Config.PageContent.TargetField] = content
+	}
+
+	plain := maps.Clone(properties)
+	if dbProps, ok := page.Properties.(notion.DatabasePageProperties); ok {
+		for name, prop := range dbProps {
+			if prop.Type != notion.DBPropTypeRichText {
+				continue
+			}
+			value := plainText(prop.RichText)
+			if value == "" {
+				value = job.Notion.Format.EmptyValue
+			}
+			plain[name] = value
+		}
+	}
+
+	return properties, plain, nil
+}
+
+func (job *SyncJob) runProcessors(ctx context.Context, page notion.Page, properties, plain map[string]string, schema notion.DatabaseProperties) (notion.Page, bool) {
+	pageID := page.ID
+	retry := false
+	writes := map[string]string{}
+	sources := map[string]processorSource{}
+	for _, processConfig := range job.Config.Processors {
+		if !processConfig.Enabled {
+			continue
+		}
+		processor, exist := processorRegistry[processConfig.Name]
+		if !exist {
+			job.Logger.Printf("Processor %s not found in registry, skipping", processConfig.Name)
+			continue
+		}
+
+		if !job.shouldRunProcessor(pageID, plain, processConfig) {
+			continue
+		}
+
+		result, err := processor.Run(ctx, processors.Note{PageID: pageID, Fields: plain, Media: job.Anki}, processConfig)
+		for _, warning := range result.Warnings {
+			job.Logger.Printf("Processor %s: %s", processConfig.Name, warning)
+		}
+		if err != nil {
+			if result.Retryable {
+				job.Logger.Printf("Processor %s failed on page %s, retrying on the next sync: %v", processConfig.Name, pageID, err)
+				retry = true
+			} else {
+				job.Logger.Printf("Error from processor %s: %v", processConfig.Name, err)
+			}
+			continue
+		}
+
+		if processConfig.Run == processors.RunIfSourceChanged {
+			sources[processConfig.Name] = processorSource{
+				hash:   sourceHash(plain[processConfig.SourceField]),
+				writes: len(result.Notion) > 0,
+			}
+		}
+		maps.Copy(properties, result.Fields)
+		maps.Copy(plain, result.Fields)
+		maps.Copy(writes, result.Notion)
+	}
+
+	updated, err := job.writeBack(ctx, page, writes, schema)
+	if err != nil {
+		job.Logger.Printf("Failed to update Notion page %s, retrying on the next sync: %v", pageID, err)
+		retry = true
+	}
+	// A processor whose results did not reach Notion has to run again, so
+	// its source is only remembered once they are written.
+	for name, source := range sources {
+		if err == nil || !source.writes {
+			job.setProcessorSource(pageID, name, source.hash)
+		}
+	}
+	return updated, retry
+}
+
+type processorSource struct {
+	hash   string
+	writes bool
+}
+
+func (job *SyncJob) shouldRunProcessor(pageID string, properties map[string]string, cfg processors.ProcessorConfig) bool {
+	switch cfg.Run {
+	case processors.RunIfEmpty:
+		value := strings.TrimSpace(properties[cfg.TargetField])
+		return value == "" || value == job.Config.PropertyFormat.EmptyValue
+	case processors.RunIfSourceChanged:
+		hash, exist := job.State.ProcessorSources[pageID][cfg.Name]
+		return !exist || hash != sourceHash(properties[cfg.SourceField])
+	}
+	return true
+}
+
+func (job *SyncJob) setProcessorSource(pageID, processor, hash string) {
+	state := job.State
+	if state.ProcessorSources == nil {
+		state.ProcessorSources = map[string]map[string]string{}
+	}
+	if state.ProcessorSources[pageID] == nil {
+		state.ProcessorSources[pageID] = map[string]string{}
+	}
+	state.ProcessorSources[pageID][processor] = hash
+}
+
+func sourceHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (job *SyncJob) addRetryPages(ctx context.Context, pages []notion.Page) []notion.Page {
+	for pageID := range job.State.RetryPages {
+		if slices.ContainsFunc(pages, func(page notion.Page) bool { return page.ID == pageID }) {
+			continue
+		}
+		page, err := job.Notion.Client.FindPageByID(ctx, pageID)
+		if err != nil {
+			var notionErr *notion.APIError
+			if errors.As(err, &notionErr) && notionErr.Status == http.StatusNotFound {
+				setRetryPage(job.State, pageID, false)
+				continue
+			}
+			job.Logger.Printf("Failed to fetch page %s to sync it again: %v", pageID, err)
+			continue
+		}
+		if page.Archived {
+			setRetryPage(job.State, pageID, false)
+			continue
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+func (job *SyncJob) saveState(store StateStore) error {
+	if job.Cache != nil {
+		if err := job.Cache.Flush(); err != nil {
+			job.Logger.Printf("Failed to save processor cache: %v", err)
+		}
+	}
+	if err := store.Save(job.State); err != nil {
+		return fmt.Errorf("failed to save sync state: %v", err)
+	}
+	return nil
+}
+
+func setRetryPage(state *SyncState, pageID string, retry bool) {
+	if !retry {
+		delete(state.RetryPages, pageID)
+		return
+	}
+	if state.RetryPages == nil {
+		state.RetryPages = map[string]bool{}
+	}
+	state.RetryPages[pageID] = true
+}
+
+func (job *SyncJob) buildNote(page notion.Page, properties, plain map[string]string) (SyncNote, error) {
+	fields, err := job.Config.FieldMapper.Apply(properties)
+	if err != nil {
+		return SyncNote{}, err
+	}
+	plainFields, err := job.Config.FieldMapper.Apply(plain)
+	if err != nil {
+		return SyncNote{}, err
+	}
+
+	deck, err := job.Config.DeckRouter.Deck(plain)
+	if err != nil {
+		return SyncNote{}, err
+	}
+
+	return SyncNote{
+		PageID:      page.ID,
+		Deck:        deck,
+		Fields:      fields,
+		PlainFields: plainFields,
+		Tags:        pageTags(page, job.Config.Tags),
+	}, nil
+}
+
+func (job *SyncJob) Run(ctx context.Context, store StateStore) {
+	schedule := job.Config.Schedule
+	job.Logger.Printf("Sync schedule: %s", schedule)
+
+	if first := schedule.First(time.Now()); first.After(time.Now()) {
+		job.Logger.Printf("Quiet hours, first sync at %s", first.Format("15:04:05"))
+		if !sleepUntil(ctx, first) {
+			job.Logger.Println("Stopping sync job")
+			return
+		}
+	}
+
+	failures := 0
+	for initial := true; ; initial = false {
+		if err := runSync(ctx, job, store); err != nil {
+			if ctx.Err() != nil {
+				job.Logger.Printf("Stopping sync job: %v", err)
+				return
+			}
+			if initial && isFatalError(err) {
+				job.Logger.Printf("Fatal error during initial sync, stopping job: %v", err)
+				return
+			}
+			failures++
+			job.Logger.Printf("fail to sync (attempt %d): %v", failures, err)
+		} else {
+			failures = 0
+		}
+
+		next := schedule.Next(time.Now(), failures)
+		if failures > 0 {
+			job.Logger.Printf("Retrying in %s at %s", time.Until(next).Round(time.Second), next.Format("15:04:05"))
+		} else {
+			job.Logger.Printf("Next sync in %s at %s", time.Until(next).Round(time.Second), next.Format("15:04:05"))
+		}
+		if !sleepUntil(ctx, next) {
+			job.Logger.Println("Stopping sync job")
+			return
+		}
+	}
+}
+
+func sleepUntil(ctx context.Context, until time.Time) bool {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func Start(ctx context.Context, jobs []*SyncJob, store StateStore) {
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job.Run(ctx, store)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		log.Println("All sync jobs stopped, shutting down")
+		return
+	}
+	log.Fatal("All sync jobs stopped, shutting down")
+}
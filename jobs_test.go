@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/notion2anki/processors"
+)
+
+type stubProcessor struct {
+	calls int
+}
+
+func (p *stubProcessor) Name() string { return "stub" }
+
+func (p *stubProcessor) Run(ctx context.Context, note processors.Note, cfg processors.ProcessorConfig) (processors.Result, error) {
+	p.calls++
+	return processors.Result{
+		Fields: map[string]string{cfg.TargetField: "result"},
+		Notion: map[string]string{cfg.TargetField: "result"},
+	}, nil
+}
+
+func TestRunProcessorsKeepsSourceUnsetWhenWriteBackFails(t *testing.T) {
+	processor := &stubProcessor{}
+	registerProcessor(processor)
+	defer delete(processorRegistry, processor.Name())
+
+	job := &SyncJob{
+		Config: &JobConfig{Processors: []processors.ProcessorConfig{{
+			Name:        processor.Name(),
+			Enabled:     true,
+			Run:         processors.RunIfSourceChanged,
+			SourceField: "Word",
+			TargetField: "Audio",
+		}}},
+		Notion: &NotionClient{},
+		State:  &SyncState{},
+		Logger: log.New(log.Writer(), "", 0),
+	}
+	page := notion.Page{ID: "page"}
+	// The schema lacks the target property, so the write-back fails.
+	schema := notion.DatabaseProperties{}
+
+	_, retry := job.runProcessors(context.Background(), page, map[string]string{"Word": "Haus"}, map[string]string{"Word": "Haus"}, schema)
+	if !retry {
+		t.Fatal("page is not retried after the failed write-back")
+	}
+	if _, exist := job.State.ProcessorSources["page"][processor.Name()]; exist {
+		t.Fatal("source hash is stored although the write-back failed")
+	}
+
+	job.runProcessors(context.Background(), page, map[string]string{"Word": "Haus"}, map[string]string{"Word": "Haus"}, schema)
+	if processor.calls != 2 {
+		t.Fatalf("processor ran %d times, want 2", processor.calls)
+	}
+}
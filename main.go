@@ -5,157 +5,511 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
+	"github.com/dstotijn/go-notion"
 	"github.com/notion2anki/processors"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
+	State          StateConfig
+	ProcessorCache ProcessorCacheConfig
+	Jobs           []*JobConfig
+}
+
+type JobConfig struct {
+	Name             string
+	StateKey         string
 	AnkiConnectURL   string
 	DeckName         string
 	ModelName        string
+	CardTemplates    CardTemplateConfig
 	NotionToken      string
 	NotionDatabaseID string
-	PollInterval     time.Duration
+	PrimaryKeyField  string
+	PropertyFormat   PropertyFormatConfig
+	Schedule         *Schedule
 	Processors       []processors.ProcessorConfig
+	Deletion         DeletionConfig
+	ReviewStats      ReviewStatsConfig
+	PageContent      PageContentConfig
+	Media            MediaConfig
+	FieldMapper      *FieldMapper
+	DeckRouter       *DeckRouter
+	Tags             []TagRule
 }
 
-var processorRegistry = make(map[string]processors.NoteProcessor)
+var processorRegistry = make(map[string]processors.Processor)
 
-func registerProcessor(p processors.NoteProcessor) {
+func registerProcessor(p processors.Processor) {
 	processorRegistry[p.Name()] = p
 }
-func loadConfig() (*Config, error) {
+func loadConfig(path string) (*Config, error) {
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yaml")
+		viper.AddConfigPath(".")
+	}
+	viper.SetDefault("state.driver", "json")
+	viper.SetDefault("state.path", "state.json")
+	viper.SetDefault("processor_cache.enabled", true)
+	viper.SetDefault("processor_cache.path", "processor_cache.json")
+	viper.SetDefault("processor_cache.ttl_hours", 720)
+	viper.SetDefault("processor_cache.negative_ttl_hours", 24)
+	viper.SetDefault("deletion.policy", DeletionPolicyNone)
+	viper.SetDefault("deletion.tag", "notion-deleted")
+	viper.SetDefault("review_stats.mature_interval_days", 21)
+	viper.SetDefault("notion.format.date_layout", "2006-01-02")
+	viper.SetDefault("notion.format.datetime_layout", "2006-01-02 15:04")
+	viper.SetDefault("notion.format.date_separator", " → ")
+	viper.SetDefault("notion.format.number_precision", -1)
+	viper.SetDefault("notion.format.checkbox_true", "true")
+	viper.SetDefault("notion.format.checkbox_false", "false")
+	viper.SetDefault("notion.format.list_separator", ", ")
+	viper.SetDefault("notion.format.empty_value", "-")
+	viper.SetDefault("notion.format.rich_text_html", true)
+	viper.SetDefault("notion.page_content.max_depth", 3)
+	viper.SetDefault("media.max_size_mb", 20)
+	viper.SetDefault("notion.poll_interval_seconds", 300)
+	viper.SetDefault("schedule.backoff.min_seconds", 30)
+	viper.SetDefault("schedule.backoff.max_seconds", 3600)
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			log.Fatal("Config file not found")
-		} else {
-			log.Fatalf("Error reading config file: %v", err)
+			return nil, fmt.Errorf("config file not found")
 		}
+		return nil, fmt.Errorf("error reading config file: %v", err)
 	}
-	pollInterval := viper.GetInt("notion.poll_interval_seconds")
 
-	if pollInterval < 0 {
-		return nil, fmt.Errorf("invalid notion.poll_interval_seconds: %d", pollInterval)
+	cfg := &Config{
+		State: StateConfig{
+			Driver: viper.GetString("state.driver"),
+			Path:   viper.GetString("state.path"),
+		},
+		ProcessorCache: ProcessorCacheConfig{
+			Enabled:     viper.GetBool("processor_cache.enabled"),
+			Path:        viper.GetString("processor_cache.path"),
+			TTL:         time.Duration(viper.GetInt("processor_cache.ttl_hours")) * time.Hour,
+			NegativeTTL: time.Duration(viper.GetInt("processor_cache.negative_ttl_hours")) * time.Hour,
+		},
+	}
+
+	var jobs []map[string]interface{}
+	if err := viper.UnmarshalKey("jobs", &jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse jobs config: %v", err)
+	}
+	if len(jobs) == 0 {
+		job, err := loadJobConfig(viper.GetViper())
+		if err != nil {
+			return nil, err
+		}
+		job.StateKey = job.NotionDatabaseID
+		cfg.Jobs = append(cfg.Jobs, job)
+		return cfg, nil
+	}
+
+	settings := viper.AllSettings()
+	delete(settings, "jobs")
+	names := map[string]bool{}
+	for i, jobSettings := range jobs {
+		v := viper.New()
+		if err := v.MergeConfigMap(settings); err != nil {
+			return nil, err
+		}
+		if err := v.MergeConfigMap(jobSettings); err != nil {
+			return nil, err
+		}
+		name := v.GetString("name")
+		if name == "" {
+			return nil, fmt.Errorf("jobs[%d]: name is required", i)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("jobs[%d]: job %s is defined more than once", i, name)
+		}
+		names[name] = true
+
+		job, err := loadJobConfig(v)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %v", name, err)
+		}
+		job.Name = name
+		job.StateKey = name
+		cfg.Jobs = append(cfg.Jobs, job)
+	}
+	return cfg, nil
+}
+
+func loadJobConfig(v *viper.Viper) (*JobConfig, error) {
+	schedule, err := NewSchedule(ScheduleConfig{
+		Interval:   time.Duration(v.GetInt("notion.poll_interval_seconds")) * time.Second,
+		Jitter:     time.Duration(v.GetInt("schedule.jitter_seconds")) * time.Second,
+		Cron:       v.GetString("schedule.cron"),
+		QuietHours: v.GetString("schedule.quiet_hours"),
+		BackoffMin: time.Duration(v.GetInt("schedule.backoff.min_seconds")) * time.Second,
+		BackoffMax: time.Duration(v.GetInt("schedule.backoff.max_seconds")) * time.Second,
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var processorConfigs []processors.ProcessorConfig
-	if err := viper.UnmarshalKey("processors", &processorConfigs); err != nil {
+	if err := v.UnmarshalKey("processors", &processorConfigs); err != nil {
 		return nil, fmt.Errorf("failed to parse processors config: %v", err)
 	}
+	for i := range processorConfigs {
+		if processorConfigs[i].Run == "" {
+			processorConfigs[i].Run = processors.RunAlways
+		}
+		if err := processorConfigs[i].Validate(); err != nil {
+			return nil, err
+		}
+		if validator, ok := processorRegistry[processorConfigs[i].Name].(processors.ConfigValidator); ok && processorConfigs[i].Enabled {
+			if err := validator.ValidateConfig(processorConfigs[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	deletion := DeletionConfig{
+		Policy: v.GetString("deletion.policy"),
+		Tag:    v.GetString("deletion.tag"),
+	}
+	if err := deletion.Validate(); err != nil {
+		return nil, err
+	}
+
+	pageContent := PageContentConfig{
+		Enabled:     v.GetBool("notion.page_content.enabled"),
+		TargetField: v.GetString("notion.page_content.target_field"),
+		MaxDepth:    v.GetInt("notion.page_content.max_depth"),
+	}
+	if pageContent.Enabled && pageContent.TargetField == "" {
+		return nil, fmt.Errorf("notion.page_content.target_field is required when page content sync is enabled")
+	}
+
+	var mappings []FieldMapping
+	if err := v.UnmarshalKey("mapping", &mappings); err != nil {
+		return nil, fmt.Errorf("failed to parse mapping config: %v", err)
+	}
+	mapper, err := NewFieldMapper(mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagRules []TagRule
+	if err := v.UnmarshalKey("anki.tags", &tagRules); err != nil {
+		return nil, fmt.Errorf("failed to parse tags config: %v", err)
+	}
+	if err := validateTagRules(tagRules); err != nil {
+		return nil, err
+	}
+
+	var deckRouting DeckRoutingConfig
+	if err := v.UnmarshalKey("anki.deck_routing", &deckRouting); err != nil {
+		return nil, fmt.Errorf("failed to parse deck routing config: %v", err)
+	}
+	deckRouter, err := NewDeckRouter(deckRouting, v.GetString("anki.deck_name"), v.GetString("notion.format.empty_value"))
+	if err != nil {
+		return nil, err
+	}
 
-	return &Config{
-		AnkiConnectURL:   viper.GetString("anki.connect_url"),
-		DeckName:         viper.GetString("anki.deck_name"),
-		ModelName:        viper.GetString("anki.model_name"),
-		NotionToken:      viper.GetString("notion.token"),
-		NotionDatabaseID: viper.GetString("notion.database_id"),
-		PollInterval:     time.Duration(pollInterval),
+	return &JobConfig{
+		AnkiConnectURL:   v.GetString("anki.connect_url"),
+		DeckName:         v.GetString("anki.deck_name"),
+		ModelName:        v.GetString("anki.model_name"),
+		NotionToken:      v.GetString("notion.token"),
+		NotionDatabaseID: v.GetString("notion.database_id"),
+		PrimaryKeyField:  v.GetString("notion.primary_key_field"),
+		Schedule:         schedule,
 		Processors:       processorConfigs,
+		CardTemplates: CardTemplateConfig{
+			FrontFile:        v.GetString("anki.templates.front"),
+			BackFile:         v.GetString("anki.templates.back"),
+			CSSFile:          v.GetString("anki.templates.css"),
+			Reverse:          v.GetBool("anki.templates.reverse"),
+			ReverseFrontFile: v.GetString("anki.templates.reverse_front"),
+			ReverseBackFile:  v.GetString("anki.templates.reverse_back"),
+			UpdateExisting:   v.GetBool("anki.templates.update_existing"),
+		},
+		PropertyFormat: PropertyFormatConfig{
+			DateLayout:      v.GetString("notion.format.date_layout"),
+			DateTimeLayout:  v.GetString("notion.format.datetime_layout"),
+			DateSeparator:   v.GetString("notion.format.date_separator"),
+			NumberPrecision: v.GetInt("notion.format.number_precision"),
+			CheckboxTrue:    v.GetString("notion.format.checkbox_true"),
+			CheckboxFalse:   v.GetString("notion.format.checkbox_false"),
+			ListSeparator:   v.GetString("notion.format.list_separator"),
+			EmptyValue:      v.GetString("notion.format.empty_value"),
+			RichTextHTML:    v.GetBool("notion.format.rich_text_html"),
+		},
+		Deletion:    deletion,
+		PageContent: pageContent,
+		FieldMapper: mapper,
+		DeckRouter:  deckRouter,
+		Tags:        tagRules,
+		Media: MediaConfig{
+			Enabled:   v.GetBool("media.enabled"),
+			MaxSizeMB: v.GetInt64("media.max_size_mb"),
+		},
+		ReviewStats: ReviewStatsConfig{
+			Enabled:            v.GetBool("review_stats.enabled"),
+			MatureIntervalDays: v.GetInt("review_stats.mature_interval_days"),
+			IntervalProperty:   v.GetString("review_stats.properties.interval"),
+			EaseProperty:       v.GetString("review_stats.properties.ease"),
+			LapsesProperty:     v.GetString("review_stats.properties.lapses"),
+			ReviewsProperty:    v.GetString("review_stats.properties.reviews"),
+			DueProperty:        v.GetString("review_stats.properties.due"),
+			StatusProperty:     v.GetString("review_stats.properties.status"),
+		},
 	}, nil
 }
 
-func sync(anki *Anki, nt *NotionClient, cfg *Config) error {
-	log.Println("🚀 Start syncing...")
-	ctx := context.Background()
+func runSync(ctx context.Context, job *SyncJob, store StateStore) error {
+	anki, nt, cfg, state, logger := job.Anki, job.Notion, job.Config, job.State, job.Logger
+	logger.Println("🚀 Start syncing...")
+	syncStartedAt := time.Now()
 
-	if err := anki.CheckAnkiConnect(); err != nil {
+	if err := anki.CheckAnkiConnect(ctx); err != nil {
 		return err
 	}
 
-	pages, pageProperties, err := nt.QueryAllPages(ctx)
+	schema, schemaChanged, err := nt.DatabaseSchema(ctx)
 	if err != nil {
 		return err
 	}
+	if schemaChanged {
+		for _, warning := range schemaWarnings(cfg, schema) {
+			logger.Printf("⚠️ %s", warning)
+		}
+	}
 
-	if err := anki.EnsureDeckExists(); err != nil {
+	pages, err := nt.QueryAllPages(ctx)
+	if err != nil {
 		return err
 	}
+	pages = job.addRetryPages(ctx, pages)
 
-	if err := anki.EnsureModelExists(pageProperties); err != nil {
+	if err := anki.EnsureDeckExists(ctx); err != nil {
 		return err
 	}
 
-	notesToAdd := []map[string]string{}
+	var extraFields []string
+	if cfg.PageContent.Enabled {
+		extraFields = append(extraFields, cfg.PageContent.TargetField)
+	}
+	mapper := cfg.FieldMapper
+	modelFields := mapper.ModelFields(schema, extraFields)
+	renames := mapper.Renames(state.PropertyNames, schema)
+	if err := anki.EnsureModelExists(ctx, modelFields, renames); err != nil {
+		return err
+	}
+	state.PropertyNames = propertyNames(schema)
+
+	if cfg.PrimaryKeyField != "" && len(modelFields) > 0 && !slices.Contains(modelFields, cfg.PrimaryKeyField) {
+		logger.Printf("Primary key field %s is not one of the note fields", cfg.PrimaryKeyField)
+	}
+
+	var media *MediaPipeline
+	if cfg.Media.Enabled {
+		media = NewMediaPipeline(anki, cfg.Media, state)
+		media.offline = job.Plan != nil
+	}
+
+	notesToAdd := []SyncNote{}
+	updatedCount := 0
+	moves := map[string][]int64{}
+	interrupted := false
+	ownEdits := 0
+	failed := map[string]bool{}
+	latest := map[string]notion.Page{}
 
 	for _, page := range pages {
-		properties := nt.ExtractPropertiesFromPage(page)
+		if ctx.Err() != nil {
+			interrupted = true
+			break
+		}
+		if page.Archived {
+			continue
+		}
+		latest[page.ID] = page
+		if !nt.LastSyncTime.IsZero() && isOwnEdit(state, page) {
+			ownEdits++
+			continue
+		}
+		delete(state.OwnEdits, page.ID)
 
-		canBeAdded, err := anki.CanAddNotes(properties)
+		properties, plain, err := job.pageProperties(ctx, page, media)
+		if err != nil {
+			logger.Printf("Failed to fetch content of page %s: %v", page.ID, err)
+			failed[page.ID] = true
+			continue
+		}
+
+		page, retry := job.runProcessors(ctx, page, properties, plain, schema)
+		latest[page.ID] = page
+		setRetryPage(state, page.ID, retry)
 
+		note, err := job.buildNote(page, properties, plain)
 		if err != nil {
-			log.Printf("Error checking if note can be added: %v", err)
+			logger.Printf("Failed to build note for page %s: %v", page.ID, err)
+			failed[page.ID] = true
+			continue
+		}
+
+		if job.Plan != nil {
+			if err := job.planNote(ctx, page, note); err != nil {
+				logger.Printf("Failed to plan note for page %s: %v", page.ID, err)
+			}
 			continue
 		}
 
-		if !canBeAdded {
-			log.Printf("Note cannot be added: %v", properties)
+		existingNote, err := anki.FindNoteForPage(ctx, page.ID, state.PageNotes[page.ID], cfg.PrimaryKeyField, note.PlainFields)
+		if err != nil {
+			logger.Printf("Error looking up existing note for page %s: %v", page.ID, err)
+			failed[page.ID] = true
 			continue
 		}
-		for _, processConfig := range cfg.Processors {
-			if !processConfig.Enabled {
+
+		if existingNote == nil {
+			delete(state.PageNotes, page.ID)
+
+			canBeAdded, err := anki.CanAddNotes(ctx, note)
+			if err != nil {
+				logger.Printf("Error checking if note can be added: %v", err)
+				failed[page.ID] = true
+				continue
+			}
+
+			if !canBeAdded {
+				logger.Printf("Note cannot be added: %v", note.Fields)
+				continue
+			}
+		}
+
+		if existingNote != nil {
+			updated, err := anki.UpdateNote(ctx, existingNote, note.Fields)
+			if err != nil {
+				logger.Printf("Failed to update note %d: %v", existingNote.NoteID, err)
+				failed[page.ID] = true
 				continue
 			}
-			processor, exist := processorRegistry[processConfig.Name]
-			if !exist {
-				log.Printf("Processor %s not found in registry, skipping", processConfig.Name)
+			state.PageNotes[page.ID] = existingNote.NoteID
+			tagsUpdated, err := anki.SyncNoteTags(ctx, existingNote, state.PageTags[page.ID], note.Tags)
+			if err != nil {
+				logger.Printf("Failed to update tags of note %d: %v", existingNote.NoteID, err)
+			} else {
+				setPageTags(state, page.ID, note.Tags)
 			}
-			if err := processor.Process(&properties, processConfig); err != nil {
-				log.Printf("Error from processor %s: %v", processConfig.Name, err)
+			if updated || tagsUpdated {
+				updatedCount++
 			}
-			if err := nt.UpdatePageOfDatabase(page, map[string]string{
-				processConfig.TargetField: properties[processConfig.TargetField],
-			}, pageProperties); err != nil {
-				log.Printf("Failed to update Notion page %s: %v", page.ID, err)
+			if cfg.DeckRouter.Enabled() {
+				moves[note.Deck] = append(moves[note.Deck], existingNote.Cards...)
 			}
+			continue
+		}
+		notesToAdd = append(notesToAdd, note)
+	}
+
+	if updatedCount > 0 {
+		logger.Printf("Updated %d existing notes in Anki.", updatedCount)
+	}
+	if ownEdits > 0 {
+		logger.Printf("Skipped %d pages whose last edit was our own write-back.", ownEdits)
+	}
+
+	// Notes already collected are written even when the sync is cancelled,
+	// so the saved state matches Anki.
+	if ctx.Err() != nil {
+		interrupted = true
+	}
+	if interrupted {
+		logger.Println("Sync interrupted, saving the changes made so far...")
+	}
+	writeCtx := context.WithoutCancel(ctx)
+
+	for deck, cardIDs := range moves {
+		moved, err := anki.MoveCards(writeCtx, cardIDs, deck)
+		if err != nil {
+			logger.Printf("Failed to move cards to deck %s: %v", deck, err)
+			continue
+		}
+		if moved > 0 {
+			logger.Printf("Moved %d cards to deck %s", moved, deck)
 		}
-		notesToAdd = append(notesToAdd, properties)
 	}
 
 	if len(notesToAdd) > 0 {
-		log.Printf("Adding %d new notes to Anki...", len(notesToAdd))
-		if err := anki.AddNotesToDeck(notesToAdd); err != nil {
-			log.Printf("Failed to add notes to Anki: %v", err)
+		logger.Printf("Adding %d new notes to Anki...", len(notesToAdd))
+		noteIDs, err := anki.AddNotesToDeck(writeCtx, notesToAdd)
+		if err != nil {
+			logger.Printf("Failed to add notes to Anki: %v", err)
+		}
+		for i, note := range notesToAdd {
+			if i >= len(noteIDs) || noteIDs[i] == 0 {
+				failed[note.PageID] = true
+				continue
+			}
+			state.PageNotes[note.PageID] = noteIDs[i]
+			setPageTags(state, note.PageID, note.Tags)
 		}
 	} else {
-		log.Println("No new notes to add.")
+		logger.Println("No new notes to add.")
 	}
 
-	nt.LastSyncTime = time.Now()
-	log.Println("Sync completed.")
-	return nil
-}
-func isFatalError(err error) bool {
-	if errors.Is(err, ErrAnkiConnectFailed) || errors.Is(err, ErrNotionAuthFailed) || errors.Is(err, ErrNotionDBNotFound) {
-		return true
+	// Failed pages are fetched again on the next sync, so one broken page
+	// does not hold back the cursor of the whole database.
+	for pageID := range failed {
+		delete(state.OwnEdits, pageID)
+		setRetryPage(state, pageID, true)
 	}
-	return false
-}
 
-func Start(anki *Anki, nt *NotionClient, cfg *Config) {
+	if interrupted || ctx.Err() != nil {
+		if job.Plan == nil {
+			if err := job.saveState(store); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("sync interrupted")
+	}
 
-	log.Printf("start: %d seconds", nt.PollInterval)
+	if err := reconcileDeletedPages(ctx, anki, nt, cfg.Deletion, state, job.Plan); err != nil {
+		logger.Printf("Failed to reconcile deleted pages: %v", err)
+	}
 
-	if err := sync(anki, nt, cfg); err != nil {
-		if isFatalError(err) {
-			log.Fatalf("Fatal error during initial sync, shutting down: %v", err)
-		}
-		log.Printf("fail to sync: %v", err)
+	if err := syncReviewStats(ctx, anki, nt, cfg.ReviewStats, state, schema, latest); err != nil {
+		logger.Printf("Failed to write review stats to Notion: %v", err)
 	}
 
-	ticker := time.NewTicker(time.Duration(nt.PollInterval) * time.Second)
-	defer ticker.Stop()
+	if job.Plan != nil {
+		logger.Println("Dry run completed, nothing was changed.")
+		return nil
+	}
 
-	for range ticker.C {
-		if err := sync(anki, nt, cfg); err != nil {
-			log.Printf("fail to sync: %v", err)
-		}
+	nt.LastSyncTime = syncStartedAt
+	state.LastSyncTime = syncStartedAt
+	if err := job.saveState(store); err != nil {
+		return err
 	}
+	if len(failed) > 0 {
+		logger.Printf("Sync completed, %d pages failed and will be synced again.", len(failed))
+		return nil
+	}
+	logger.Println("Sync completed.")
+	return nil
+}
+func isFatalError(err error) bool {
+	if errors.Is(err, ErrAnkiConnectFailed) || errors.Is(err, ErrNotionAuthFailed) || errors.Is(err, ErrNotionDBNotFound) {
+		return true
+	}
+	return false
 }
 
 func init() {
@@ -163,14 +517,15 @@ func init() {
 }
 
 func main() {
-	cfg, err := loadConfig()
-	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
-	}
-	anki := NewAnki(cfg.AnkiConnectURL, cfg.DeckName, cfg.ModelName)
-
-	nt := NewNotion(cfg.NotionToken, cfg.NotionDatabaseID, cfg.PollInterval)
-
-	Start(anki, nt, cfg)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down, press Ctrl+C again to force")
+		stop()
+	}()
 
+	if err := runCLI(ctx, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/dstotijn/go-notion"
+)
+
+type FieldMapping struct {
+	Field    string `mapstructure:"field"`
+	Property string `mapstructure:"property"`
+	Value    string `mapstructure:"value"`
+	Template string `mapstructure:"template"`
+}
+
+type FieldMapper struct {
+	mappings  []FieldMapping
+	templates map[string]*template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+func NewFieldMapper(mappings []FieldMapping) (*FieldMapper, error) {
+	mapper := &FieldMapper{
+		mappings:  mappings,
+		templates: map[string]*template.Template{},
+	}
+
+	seen := map[string]bool{}
+	for i, mapping := range mappings {
+		if mapping.Field == "" {
+			return nil, fmt.Errorf("mapping[%d]: field is required", i)
+		}
+		if seen[mapping.Field] {
+			return nil, fmt.Errorf("mapping[%d]: field %s is mapped more than once", i, mapping.Field)
+		}
+		seen[mapping.Field] = true
+
+		sources := 0
+		for _, source := range []string{mapping.Property, mapping.Value, mapping.Template} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("mapping[%d]: field %s needs exactly one of property, value or template", i, mapping.Field)
+		}
+
+		if mapping.Template != "" {
+			tmpl, err := template.New(mapping.Field).Funcs(templateFuncs).Option("missingkey=zero").Parse(mapping.Template)
+			if err != nil {
+				return nil, fmt.Errorf("mapping[%d]: invalid template for field %s: %v", i, mapping.Field, err)
+			}
+			mapper.templates[mapping.Field] = tmpl
+		}
+	}
+
+	return mapper, nil
+}
+
+func (m *FieldMapper) Enabled() bool {
+	return len(m.mappings) > 0
+}
+
+func (m *FieldMapper) FieldNames() []string {
+	names := make([]string, 0, len(m.mappings))
+	for _, mapping := range m.mappings {
+		names = append(names, mapping.Field)
+	}
+	return names
+}
+
+func (m *FieldMapper) ModelFields(schema notion.DatabaseProperties, extraFields []string) []string {
+	if m.Enabled() {
+		return m.FieldNames()
+	}
+
+	var title string
+	var names []string
+	for name, prop := range schema {
+		if prop.Type == notion.DBPropTypeTitle {
+			title = name
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if title != "" {
+		names = append([]string{title}, names...)
+	}
+
+	for _, name := range extraFields {
+		if _, exist := schema[name]; !exist {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (m *FieldMapper) Apply(properties map[string]string) (map[string]string, error) {
+	if !m.Enabled() {
+		return properties, nil
+	}
+
+	fields := make(map[string]string, len(m.mappings))
+	for _, mapping := range m.mappings {
+		switch {
+		case mapping.Property != "":
+			fields[mapping.Field] = properties[mapping.Property]
+		case mapping.Value != "":
+			fields[mapping.Field] = mapping.Value
+		default:
+			var buf bytes.Buffer
+			if err := m.templates[mapping.Field].Execute(&buf, properties); err != nil {
+				return nil, fmt.Errorf("failed to render field %s: %v", mapping.Field, err)
+			}
+			fields[mapping.Field] = buf.String()
+		}
+	}
+	return fields, nil
+}
+
+func (m *FieldMapper) Renames(previousNames map[string]string, schema notion.DatabaseProperties) map[string]string {
+	if m.Enabled() {
+		return nil
+	}
+
+	renames := map[string]string{}
+	for name, prop := range schema {
+		previous, exist := previousNames[prop.ID]
+		if exist && previous != name {
+			renames[previous] = name
+		}
+	}
+	return renames
+}
+
+func propertyNames(schema notion.DatabaseProperties) map[string]string {
+	names := make(map[string]string, len(schema))
+	for name, prop := range schema {
+		if prop.ID != "" {
+			names[prop.ID] = name
+		}
+	}
+	return names
+}
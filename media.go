@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+)
+
+var (
+	imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg", ".bmp"}
+	soundExtensions = []string{".mp3", ".ogg", ".wav", ".m4a", ".aac", ".flac", ".opus", ".mp4", ".webm"}
+)
+
+type MediaConfig struct {
+	Enabled   bool
+	MaxSizeMB int64
+}
+
+var errMediaNotStored = errors.New("media is not stored in Anki yet")
+
+type MediaPipeline struct {
+	anki       *Anki
+	httpClient *http.Client
+	maxSize    int64
+	state      *SyncState
+	offline    bool
+}
+
+func NewMediaPipeline(anki *Anki, cfg MediaConfig, state *SyncState) *MediaPipeline {
+	if state.Media == nil {
+		state.Media = map[string]string{}
+	}
+	return &MediaPipeline{
+		anki:       anki,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		maxSize:    cfg.MaxSizeMB * 1024 * 1024,
+		state:      state,
+	}
+}
+
+func (m *MediaPipeline) Store(ctx context.Context, rawURL, name string) (string, error) {
+	key := mediaKey(rawURL)
+	if filename, exist := m.state.Media[key]; exist {
+		return filename, nil
+	}
+	if m.offline {
+		return "", errMediaNotStored
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid media URL: %v", err)
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fail to download media: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fail to download media: HTTP %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, m.maxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("fail to read media: %v", err)
+	}
+	if int64(len(data)) > m.maxSize {
+		return "", fmt.Errorf("media larger than %d bytes", m.maxSize)
+	}
+
+	sum := sha256.Sum256(data)
+	filename := "notion-" + hex.EncodeToString(sum[:])[:16] + mediaExtension(rawURL, name, resp.Header.Get("Content-Type"))
+
+	filename, err = m.anki.StoreMediaFile(ctx, filename, base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return "", err
+	}
+
+	m.state.Media[key] = filename
+	m.anki.logger.Printf("Stored media %s in Anki", filename)
+	return filename, nil
+}
+
+func (m *MediaPipeline) FilesField(ctx context.Context, files []notion.File) (string, error) {
+	var references []string
+	for _, file := range files {
+		rawURL := fileURL(file)
+		if rawURL == "" {
+			continue
+		}
+		filename, err := m.Store(ctx, rawURL, file.Name)
+		if errors.Is(err, errMediaNotStored) {
+			references = append(references, rawURL)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("fail to store file %s: %v", file.Name, err)
+		}
+		references = append(references, MediaReference(filename))
+	}
+	return strings.Join(references, " "), nil
+}
+
+// ReplaceFileProperties replaces the file properties with references to the
+// files stored in Anki. It fails when a file cannot be stored, so the page is
+// synced again instead of losing the file.
+func (m *MediaPipeline) ReplaceFileProperties(ctx context.Context, page notion.Page, properties map[string]string) error {
+	dbProps, ok := page.Properties.(notion.DatabasePageProperties)
+	if !ok {
+		return nil
+	}
+	for name, prop := range dbProps {
+		if prop.Type != notion.DBPropTypeFiles || len(prop.Files) == 0 {
+			continue
+		}
+		value, err := m.FilesField(ctx, prop.Files)
+		if err != nil {
+			return err
+		}
+		if value != "" {
+			properties[name] = value
+		}
+	}
+	return nil
+}
+
+func MediaReference(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	for _, imageExt := range imageExtensions {
+		if ext == imageExt {
+			return fmt.Sprintf(`<img src="%s">`, filename)
+		}
+	}
+	for _, soundExt := range soundExtensions {
+		if ext == soundExt {
+			return fmt.Sprintf("[sound:%s]", filename)
+		}
+	}
+	return filename
+}
+
+func mediaKey(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	parsed.Fragment = ""
+
+	// Signed URLs change on every request, so only the signing parameters
+	// are dropped; the rest of the query may identify the file.
+	query := parsed.Query()
+	removed := false
+	for name := range query {
+		if isSigningParameter(parsed.Host, name) {
+			query.Del(name)
+			removed = true
+		}
+	}
+	if removed {
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.String()
+}
+
+func isSigningParameter(host, name string) bool {
+	if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+		return true
+	}
+	notionHost := host == "notion.so" || strings.HasSuffix(host, ".notion.so")
+	return notionHost && (name == "expirationTimestamp" || name == "signature")
+}
+
+func mediaExtension(rawURL, name, contentType string) string {
+	if parsed, err := url.Parse(rawURL); err == nil {
+		if ext := path.Ext(parsed.Path); ext != "" {
+			return strings.ToLower(ext)
+		}
+	}
+	if ext := path.Ext(name); ext != "" {
+		return strings.ToLower(ext)
+	}
+	if contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+			return extensions[0]
+		}
+	}
+	return ""
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,7 +23,12 @@ type NotionClient struct {
 	Config       NotionConfig
 	Client       *notion.Client
 	LastSyncTime time.Time
-	PollInterval time.Duration
+	Format       PropertyFormatConfig
+
+	logger           *log.Logger
+	recordWrite      func(pageID string, props map[string]string)
+	schema           notion.DatabaseProperties
+	schemaEditedTime time.Time
 }
 
 type NotionConfig struct {
@@ -45,7 +51,7 @@ func get1PasswordSecret(reference string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-func NewNotion(tokenRef, databaseID string, interval time.Duration) *NotionClient {
+func NewNotion(tokenRef, databaseID string) *NotionClient {
 	token, err := get1PasswordSecret(tokenRef)
 	if err != nil {
 		log.Println("Failed to get Notion token from 1Password")
@@ -58,23 +64,14 @@ func NewNotion(tokenRef, databaseID string, interval time.Duration) *NotionClien
 			DatabaseID: databaseID,
 			Token:      token,
 		},
-		Client:       client,
-		LastSyncTime: time.Now().Add(-100 * time.Hour),
-		PollInterval: time.Duration(interval),
+		Client: client,
+		logger: log.Default(),
 	}
 }
 
-func (nt *NotionClient) QueryNotionDatabase(ctx context.Context, cursor string) (notion.DatabaseQueryResponse, error) {
+func (nt *NotionClient) QueryNotionDatabase(ctx context.Context, cursor string, since time.Time) (notion.DatabaseQueryResponse, error) {
 
-	result, err := nt.Client.QueryDatabase(ctx, nt.Config.DatabaseID, &notion.DatabaseQuery{
-		Filter: &notion.DatabaseQueryFilter{
-			Timestamp: notion.TimestampLastEditedTime,
-			DatabaseQueryPropertyFilter: notion.DatabaseQueryPropertyFilter{
-				LastEditedTime: &notion.DatePropertyFilter{
-					After: &nt.LastSyncTime,
-				},
-			},
-		},
+	query := &notion.DatabaseQuery{
 		Sorts: []notion.DatabaseQuerySort{
 			{
 				Timestamp: notion.TimestampLastEditedTime,
@@ -82,31 +79,50 @@ func (nt *NotionClient) QueryNotionDatabase(ctx context.Context, cursor string)
 			},
 		},
 		StartCursor: cursor,
-	})
-	if err != nil {
-		var notionErr *notion.APIError
-		if errors.As(err, &notionErr) {
-			if notionErr.Status == http.StatusUnauthorized {
-				return notion.DatabaseQueryResponse{}, ErrNotionAuthFailed
-			}
-			if notionErr.Status == http.StatusNotFound {
-				return notion.DatabaseQueryResponse{}, ErrNotionDBNotFound
-			}
+	}
+	if !since.IsZero() {
+		// Notion rounds last_edited_time down to the minute, so a page edited
+		// in the minute the last sync started looks older than the cursor.
+		after := since.Truncate(time.Minute).Add(-time.Minute)
+		query.Filter = &notion.DatabaseQueryFilter{
+			Timestamp: notion.TimestampLastEditedTime,
+			DatabaseQueryPropertyFilter: notion.DatabaseQueryPropertyFilter{
+				LastEditedTime: &notion.DatePropertyFilter{
+					After: &after,
+				},
+			},
 		}
-		return notion.DatabaseQueryResponse{}, fmt.Errorf("failed to query Notion database: %v", err)
+	}
+
+	result, err := nt.Client.QueryDatabase(ctx, nt.Config.DatabaseID, query)
+	if err != nil {
+		return notion.DatabaseQueryResponse{}, databaseError(err, "failed to query Notion database")
 	}
 
 	return result, nil
 }
 
-func (nt *NotionClient) QueryAllPages(ctx context.Context) ([]notion.Page, notion.DatabasePageProperties, error) {
+func databaseError(err error, message string) error {
+	var notionErr *notion.APIError
+	if errors.As(err, &notionErr) {
+		if notionErr.Status == http.StatusUnauthorized {
+			return ErrNotionAuthFailed
+		}
+		if notionErr.Status == http.StatusNotFound {
+			return ErrNotionDBNotFound
+		}
+	}
+	return fmt.Errorf("%s: %v", message, err)
+}
+
+func (nt *NotionClient) QueryAllPages(ctx context.Context) ([]notion.Page, error) {
 	var allPages []notion.Page
 	var cursor string
 
 	for {
-		result, err := nt.QueryNotionDatabase(ctx, cursor)
+		result, err := nt.QueryNotionDatabase(ctx, cursor, nt.LastSyncTime)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 
 		allPages = append(allPages, result.Results...)
@@ -117,15 +133,55 @@ func (nt *NotionClient) QueryAllPages(ctx context.Context) ([]notion.Page, notio
 		cursor = *result.NextCursor
 	}
 
-	var pageProperties notion.DatabasePageProperties
-	if len(allPages) > 0 {
-		page := allPages[0]
-		if dbProps, ok := page.Properties.(notion.DatabasePageProperties); ok {
-			pageProperties = dbProps
+	return allPages, nil
+}
+
+func (nt *NotionClient) QueryAllPageIDs(ctx context.Context) (map[string]bool, error) {
+	pageIDs := map[string]bool{}
+	var cursor string
+
+	for {
+		result, err := nt.QueryNotionDatabase(ctx, cursor, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, page := range result.Results {
+			if !page.Archived {
+				pageIDs[page.ID] = true
+			}
 		}
+
+		if !result.HasMore {
+			break
+		}
+		cursor = *result.NextCursor
+	}
+
+	return pageIDs, nil
+}
+
+func (nt *NotionClient) DatabaseSchema(ctx context.Context) (notion.DatabaseProperties, bool, error) {
+	db, err := nt.Client.FindDatabaseByID(ctx, nt.Config.DatabaseID)
+	if err != nil {
+		return nil, false, databaseError(err, "failed to fetch Notion database schema")
 	}
 
-	return allPages, pageProperties, nil
+	changed := nt.schema == nil || !db.LastEditedTime.Equal(nt.schemaEditedTime)
+	nt.schema = db.Properties
+	nt.schemaEditedTime = db.LastEditedTime
+	return nt.schema, changed, nil
+}
+
+func isWritableProperty(propType notion.DatabasePropertyType) bool {
+	switch propType {
+	case notion.DBPropTypeTitle, notion.DBPropTypeRichText, notion.DBPropTypeSelect,
+		notion.DBPropTypeStatus, notion.DBPropTypeMultiSelect, notion.DBPropTypeURL,
+		notion.DBPropTypeEmail, notion.DBPropTypePhoneNumber, notion.DBPropTypeNumber,
+		notion.DBPropTypeCheckbox, notion.DBPropTypeDate:
+		return true
+	}
+	return false
 }
 
 func (nt *NotionClient) ExtractPropertiesFromPage(page notion.Page) map[string]string {
@@ -135,64 +191,28 @@ func (nt *NotionClient) ExtractPropertiesFromPage(page notion.Page) map[string]s
 	}
 	if dbProps, ok := page.Properties.(notion.DatabasePageProperties); ok {
 		for name, prop := range dbProps {
-			switch prop.Type {
-			case notion.DBPropTypeTitle:
-				if len(prop.Title) > 0 {
-					text := prop.Title[0].PlainText
-					if text != "" {
-						properties[name] = text
-					}
-				} else {
-					properties[name] = "-"
-				}
-			case notion.DBPropTypeURL:
-				if prop.URL != nil && *prop.URL != "" {
-					properties[name] = *prop.URL
-				} else {
-					properties[name] = "-"
-				}
-			case notion.DBPropTypeSelect:
-				if prop.Select != nil && prop.Select.Name != "" {
-					properties[name] = prop.Select.Name
-				} else {
-					properties[name] = "-"
-				}
-			case notion.DBPropTypeMultiSelect:
-				var multiSelectValues []string
-				for _, option := range prop.MultiSelect {
-					if option.Name != "" {
-						multiSelectValues = append(multiSelectValues, option.Name)
-					}
-				}
-				if len(multiSelectValues) > 0 {
-					properties[name] = strings.Join(multiSelectValues, ", ")
-				} else {
-					properties[name] = "-"
-				}
-			case notion.DBPropTypeRichText:
-				var richTextValues []string
-				for _, text := range prop.RichText {
-					if text.PlainText != "" {
-						richTextValues = append(richTextValues, text.PlainText)
-					}
-				}
-				if len(richTextValues) > 0 {
-					properties[name] = strings.Join(richTextValues, ", ")
-				} else {
-					properties[name] = "-"
-				}
+			if _, supported := propertyConverters[prop.Type]; !supported {
+				continue
 			}
+			value := convertProperty(prop, nt.Format)
+			if value == "" {
+				value = nt.Format.EmptyValue
+			}
+			properties[name] = value
 		}
 	}
 	return properties
 }
 
-func (nt *NotionClient) UpdatePageOfDatabase(page notion.Page, props map[string]string, pageProperties notion.DatabasePageProperties) error {
+func (nt *NotionClient) UpdatePageOfDatabase(ctx context.Context, pageID string, props map[string]string, schema notion.DatabaseProperties) (notion.Page, error) {
 	params := notion.UpdatePageParams{
 		DatabasePageProperties: notion.DatabasePageProperties{},
 	}
 	for name, value := range props {
-		prop := pageProperties[name]
+		prop, exist := schema[name]
+		if !exist {
+			return notion.Page{}, fmt.Errorf("property %s does not exist in the Notion database", name)
+		}
 		property := notion.DatabasePageProperty{}
 
 		switch prop.Type {
@@ -202,18 +222,47 @@ func (nt *NotionClient) UpdatePageOfDatabase(page notion.Page, props map[string]
 			property.RichText = []notion.RichText{{Text: &notion.Text{Content: value}}}
 		case notion.DBPropTypeSelect:
 			property.Select = &notion.SelectOptions{Name: value}
+		case notion.DBPropTypeStatus:
+			property.Status = &notion.SelectOptions{Name: value}
 		case notion.DBPropTypeMultiSelect:
-			options := strings.Split(value, ", ")
+			options := strings.Split(value, nt.Format.ListSeparator)
 			for _, opt := range options {
 				property.MultiSelect = append(property.MultiSelect, notion.SelectOptions{Name: opt})
 			}
 		case notion.DBPropTypeURL:
 			property.URL = &value
+		case notion.DBPropTypeEmail:
+			property.Email = &value
+		case notion.DBPropTypePhoneNumber:
+			property.PhoneNumber = &value
+		case notion.DBPropTypeNumber:
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return notion.Page{}, fmt.Errorf("invalid number for property %s: %q", name, value)
+			}
+			property.Number = &number
+		case notion.DBPropTypeCheckbox:
+			checked, err := strconv.ParseBool(value)
+			if err != nil {
+				return notion.Page{}, fmt.Errorf("invalid checkbox value for property %s: %q", name, value)
+			}
+			property.Checkbox = &checked
+		case notion.DBPropTypeDate:
+			date, err := notion.ParseDateTime(value)
+			if err != nil {
+				return notion.Page{}, fmt.Errorf("invalid date for property %s: %q", name, value)
+			}
+			property.Date = &notion.Date{Start: date}
+		default:
+			return notion.Page{}, fmt.Errorf("property %s has unsupported type %q for write-back", name, prop.Type)
 		}
 
 		params.DatabasePageProperties[name] = property
 
 	}
-	_, err := nt.Client.UpdatePage(context.Background(), page.ID, params)
-	return err
+	if nt.recordWrite != nil {
+		nt.recordWrite(pageID, props)
+		return notion.Page{}, nil
+	}
+	return nt.Client.UpdatePage(ctx, pageID, params)
 }
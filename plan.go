@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+type PlannedNote struct {
+	PageID string   `json:"page_id"`
+	NoteID int64    `json:"note_id,omitempty"`
+	Title  string   `json:"title,omitempty"`
+	Deck   string   `json:"deck,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Action string   `json:"action,omitempty"`
+}
+
+type PlannedMove struct {
+	Deck    string  `json:"deck"`
+	CardIDs []int64 `json:"card_ids"`
+}
+
+type PlannedWrite struct {
+	PageID     string            `json:"page_id"`
+	Properties map[string]string `json:"properties"`
+}
+
+type SyncPlan struct {
+	Job       string         `json:"job"`
+	Add       []PlannedNote  `json:"add"`
+	Update    []PlannedNote  `json:"update"`
+	Delete    []PlannedNote  `json:"delete"`
+	Move      []PlannedMove  `json:"move"`
+	WriteBack []PlannedWrite `json:"write_back"`
+}
+
+func (job *SyncJob) EnableDryRun() {
+	plan := &SyncPlan{
+		Job:       job.Config.DisplayName(),
+		Add:       []PlannedNote{},
+		Update:    []PlannedNote{},
+		Delete:    []PlannedNote{},
+		Move:      []PlannedMove{},
+		WriteBack: []PlannedWrite{},
+	}
+	job.Plan = plan
+	job.Anki.readOnly = true
+	if job.Cache != nil {
+		job.Cache.readOnly = true
+	}
+	job.Notion.recordWrite = func(pageID string, props map[string]string) {
+		values := make(map[string]string, len(props))
+		for name, value := range props {
+			values[name] = value
+		}
+		plan.WriteBack = append(plan.WriteBack, PlannedWrite{PageID: pageID, Properties: values})
+	}
+}
+
+func (job *SyncJob) planNote(ctx context.Context, page notion.Page, note SyncNote) error {
+	anki, state := job.Anki, job.State
+
+	existingNote, _, err := anki.LookupNoteForPage(ctx, page.ID, state.PageNotes[page.ID], job.Config.PrimaryKeyField, note.PlainFields)
+	if err != nil {
+		return err
+	}
+
+	if existingNote == nil {
+		canBeAdded, err := anki.CanAddNotes(ctx, note)
+		if err != nil {
+			return err
+		}
+		if !canBeAdded {
+			job.Logger.Printf("Note cannot be added: %v", note.Fields)
+			return nil
+		}
+		job.Plan.Add = append(job.Plan.Add, PlannedNote{
+			PageID: page.ID,
+			Title:  pageTitle(page),
+			Deck:   anki.noteDeck(note),
+			Tags:   note.Tags,
+		})
+		return nil
+	}
+
+	update := PlannedNote{PageID: page.ID, NoteID: existingNote.NoteID, Title: pageTitle(page)}
+	update.Fields = mapKeys(changedFields(existingNote, note.Fields))
+	// Fields the note type would get are filled in by the update as well.
+	for _, field := range anki.pendingFields {
+		if _, exist := existingNote.Fields[field]; exist {
+			continue
+		}
+		current := existingNote.Fields[anki.pendingRenames[field]].Value
+		if note.Fields[field] != current {
+			update.Fields = append(update.Fields, field)
+		}
+	}
+	sort.Strings(update.Fields)
+	if tags, changed := mergeTags(existingNote.Tags, state.PageTags[page.ID], note.Tags); changed {
+		update.Tags = tags
+	}
+	if len(update.Fields) > 0 || len(update.Tags) > 0 {
+		job.Plan.Update = append(job.Plan.Update, update)
+	}
+
+	if job.Config.DeckRouter.Enabled() {
+		misplaced, err := anki.MisplacedCards(ctx, existingNote.Cards, note.Deck)
+		if err != nil {
+			return err
+		}
+		if len(misplaced) > 0 {
+			job.Plan.Move = append(job.Plan.Move, PlannedMove{Deck: note.Deck, CardIDs: misplaced})
+		}
+	}
+	return nil
+}
+
+func (plan *SyncPlan) Empty() bool {
+	return len(plan.Add) == 0 && len(plan.Update) == 0 && len(plan.Delete) == 0 &&
+		len(plan.Move) == 0 && len(plan.WriteBack) == 0
+}
+
+func (plan *SyncPlan) Print(w io.Writer) {
+	fmt.Fprintf(w, "%s: %d to add, %d to update, %d to delete, %d moves, %d Notion write-backs\n",
+		plan.Job, len(plan.Add), len(plan.Update), len(plan.Delete), len(plan.Move), len(plan.WriteBack))
+	if plan.Empty() {
+		fmt.Fprintln(w, "  Nothing to change.")
+		return
+	}
+
+	for _, note := range plan.Add {
+		fmt.Fprintf(w, "  + %s → %s (page %s)\n", note.Title, note.Deck, note.PageID)
+	}
+	for _, note := range plan.Update {
+		changes := note.Fields
+		if len(note.Tags) > 0 {
+			changes = append(changes[:len(changes):len(changes)], "tags: "+strings.Join(note.Tags, " "))
+		}
+		fmt.Fprintf(w, "  ~ %s (note %d): %s\n", note.Title, note.NoteID, strings.Join(changes, ", "))
+	}
+	for _, note := range plan.Delete {
+		fmt.Fprintf(w, "  - note %d (page %s): %s\n", note.NoteID, note.PageID, note.Action)
+	}
+	for _, move := range plan.Move {
+		fmt.Fprintf(w, "  → %d cards to %s\n", len(move.CardIDs), move.Deck)
+	}
+	for _, write := range plan.WriteBack {
+		names := mapKeys(write.Properties)
+		sort.Strings(names)
+		var values []string
+		for _, name := range names {
+			values = append(values, fmt.Sprintf("%s=%q", name, write.Properties[name]))
+		}
+		fmt.Fprintf(w, "  ✎ page %s: %s\n", write.PageID, strings.Join(values, ", "))
+	}
+}
@@ -1,9 +1,13 @@
 package processors
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -14,12 +18,38 @@ import (
 
 type DWDSAudioProcessor struct {
 	client *resty.Client
+	cache  Cache
 }
 
 const (
 	baseURL = "https://www.dwds.de"
 )
 
+const (
+	audioModeURL   = "url"
+	audioModeSound = "sound"
+)
+
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("HTTP status code: %d", e.code)
+}
+
+// isRetryable reports whether a lookup may succeed on a later sync: network
+// errors, timeouts, rate limiting and server errors are, other HTTP statuses
+// and parse errors are not.
+func isRetryable(err error) bool {
+	var status statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
 type AudioInfo struct {
 	URL      string
 	Format   string
@@ -31,26 +61,155 @@ func (p *DWDSAudioProcessor) Name() string {
 	return "dwds_audio"
 }
 
-func (p *DWDSAudioProcessor) Process(noteData *map[string]string, config ProcessorConfig) error {
+func (p *DWDSAudioProcessor) SetCache(cache Cache) {
+	p.cache = cache
+}
+
+func audioMode(config ProcessorConfig) string {
+	mode, _ := config.Config["mode"].(string)
+	if mode == "" {
+		return audioModeURL
+	}
+	return mode
+}
+
+func (p *DWDSAudioProcessor) ValidateConfig(config ProcessorConfig) error {
+	if config.SourceField == "" || config.TargetField == "" {
+		return errors.New("dwds_audio processor requires 'source_field' and 'target_field' in its config")
+	}
+	mode := audioMode(config)
+	if mode != audioModeURL && mode != audioModeSound {
+		return fmt.Errorf("dwds_audio processor has unknown mode %q", mode)
+	}
+	// A skipped run would build the note from the URL stored in Notion and
+	// replace the [sound:] tag with it.
+	keepURL, _ := config.Config["keep_url_in_notion"].(bool)
+	if mode == audioModeSound && keepURL && config.Run != RunAlways && config.Run != "" {
+		return fmt.Errorf("dwds_audio processor with mode %q and keep_url_in_notion needs run: %s", mode, RunAlways)
+	}
+	return nil
+}
+
+func (p *DWDSAudioProcessor) Run(ctx context.Context, note Note, config ProcessorConfig) (Result, error) {
+	if err := p.ValidateConfig(config); err != nil {
+		return Result{}, err
+	}
 	sourceField := config.SourceField
 	targetField := config.TargetField
-	if sourceField == "" || targetField == "" {
-		return errors.New("dwds_audio processor requires 'source_field' and 'target_field' in its config")
+	mode := audioMode(config)
+	keepURL, _ := config.Config["keep_url_in_notion"].(bool)
+
+	source := note.Fields[sourceField]
+	if source == "" {
+		return Result{}, nil
+	}
+	audioInfo, err := p.lookupAudioURL(ctx, source)
+	if err != nil {
+		return Result{Retryable: isRetryable(err)}, fmt.Errorf("could not fetch audio for '%s': %v", source, err)
+	}
+	if !audioInfo.Found {
+		return Result{Warnings: []string{fmt.Sprintf("no audio found for '%s'", source)}}, nil
+	}
+
+	if mode == audioModeURL {
+		return Result{
+			Fields: map[string]string{targetField: audioInfo.URL},
+			Notion: map[string]string{targetField: audioInfo.URL},
+		}, nil
+	}
+
+	filename := audioFilename(source, audioInfo)
+	// The file name only depends on the word, so a note that already plays
+	// it does not need the MP3 again.
+	if note.Fields[targetField] != soundTag(filename) {
+		filename, err = p.storeAudio(ctx, note.Media, filename, audioInfo)
+		if err != nil {
+			return Result{Retryable: isRetryable(err)}, fmt.Errorf("could not store audio for '%s': %v", source, err)
+		}
+	}
+	sound := soundTag(filename)
+	notionValue := sound
+	if keepURL {
+		notionValue = audioInfo.URL
+	}
+	return Result{
+		Fields: map[string]string{targetField: sound},
+		Notion: map[string]string{targetField: notionValue},
+	}, nil
+}
+
+func (p *DWDSAudioProcessor) lookupAudioURL(ctx context.Context, word string) (AudioInfo, error) {
+	if p.cache != nil {
+		if entry, exist := p.cache.Get(p.Name(), word); exist {
+			if !entry.Found {
+				return AudioInfo{Found: false, ErrorMsg: "No audio link found"}, nil
+			}
+			return AudioInfo{URL: entry.Value, Format: p.detectAudioFormat(entry.Value), Found: true}, nil
+		}
+	}
+
+	log.Printf("[%s] Processing source: '%s'", p.Name(), word)
+	audioInfo, err := p.GetAudioURL(ctx, word)
+	if err != nil || p.cache == nil {
+		return audioInfo, err
+	}
+	if err := p.cache.Put(p.Name(), word, CacheEntry{Value: audioInfo.URL, Found: audioInfo.Found}); err != nil {
+		log.Printf("[%s] Failed to cache audio URL for '%s': %v", p.Name(), word, err)
 	}
-	source, exist := (*noteData)[sourceField]
-	if !exist || source == "" {
-		return nil
+	return audioInfo, nil
+}
+
+func (p *DWDSAudioProcessor) storeAudio(ctx context.Context, store MediaStore, filename string, audioInfo AudioInfo) (string, error) {
+	if store == nil {
+		return "", errors.New("no media store configured")
 	}
-	log.Printf("[%s] Processing source: '%s'", p.Name(), source)
-	audioInfo, err := p.GetAudioURL(source)
+	exist, err := store.MediaFileExists(ctx, filename)
 	if err != nil {
-		log.Printf("Could not fetch audio for '%s': %v", source, err)
-		return nil
+		return "", err
 	}
-	if audioInfo.Found {
-		(*noteData)[targetField] = audioInfo.URL
+	if exist {
+		return filename, nil
 	}
-	return nil
+
+	resp, err := p.client.R().
+		SetContext(ctx).
+		SetHeader("Referer", fmt.Sprintf("%s/", baseURL)).
+		Get(audioInfo.URL)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode() != 200 {
+		return "", statusError{code: resp.StatusCode()}
+	}
+
+	return store.StoreMediaFile(ctx, filename, base64.StdEncoding.EncodeToString(resp.Body()))
+}
+
+func audioFilename(word string, audioInfo AudioInfo) string {
+	ext := audioInfo.Format
+	if ext == "unknown" {
+		ext = "mp3"
+	}
+	return fmt.Sprintf("dwds-%s.%s", audioFileName(word), ext)
+}
+
+func soundTag(filename string) string {
+	return fmt.Sprintf("[sound:%s]", filename)
+}
+
+func audioFileName(word string) string {
+	replacer := strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss")
+	word = replacer.Replace(strings.ToLower(strings.TrimSpace(word)))
+
+	var builder strings.Builder
+	for _, r := range word {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune('_')
+		}
+	}
+	return builder.String()
 }
 
 func NewDWDSAudioProcessor() *DWDSAudioProcessor {
@@ -78,11 +237,12 @@ func NewDWDSAudioProcessor() *DWDSAudioProcessor {
 	}
 }
 
-func (p *DWDSAudioProcessor) GetAudioURL(word string) (AudioInfo, error) {
+func (p *DWDSAudioProcessor) GetAudioURL(ctx context.Context, word string) (AudioInfo, error) {
 
 	dwdsURL := fmt.Sprintf("%s/wb/%s", baseURL, url.QueryEscape(strings.ToLower(word)))
 
 	resp, err := p.client.R().
+		SetContext(ctx).
 		SetHeader("Referer", fmt.Sprintf("%s/", baseURL)).
 		Get(dwdsURL)
 
@@ -90,10 +250,13 @@ func (p *DWDSAudioProcessor) GetAudioURL(word string) (AudioInfo, error) {
 		return AudioInfo{ErrorMsg: "fail to fetch audio URL"}, err
 	}
 
+	if resp.StatusCode() == 404 {
+		return AudioInfo{Found: false, ErrorMsg: "word not found"}, nil
+	}
 	if resp.StatusCode() != 200 {
 		return AudioInfo{
 			ErrorMsg: fmt.Sprintf("HTTP error: %d", resp.StatusCode()),
-		}, fmt.Errorf("HTTP status code: %d", resp.StatusCode())
+		}, statusError{code: resp.StatusCode()}
 	}
 
 	html := resp.String()
@@ -216,15 +379,3 @@ func (p *DWDSAudioProcessor) detectAudioFormat(url string) string {
 
 	return "unknown"
 }
-
-func (p *DWDSAudioProcessor) ValidateAudioURL(audioURL string) bool {
-	resp, err := p.client.R().
-		SetHeader("Referer", fmt.Sprintf("%s/", baseURL)).
-		Head(audioURL)
-
-	if err != nil {
-		return false
-	}
-
-	return resp.StatusCode() == 200
-}
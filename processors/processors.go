@@ -1,14 +1,94 @@
 package processors
 
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	RunAlways          = "always"
+	RunIfEmpty         = "if_empty"
+	RunIfSourceChanged = "if_source_changed"
+)
+
 type ProcessorConfig struct {
 	Name        string                 `mapstructure:"name"`
 	Enabled     bool                   `mapstructure:"enabled"`
 	TargetField string                 `mapstructure:"target_field"`
 	SourceField string                 `mapstructure:"source_field"`
+	Run         string                 `mapstructure:"run"`
 	Config      map[string]interface{} `mapstructure:"config"`
 }
 
-type NoteProcessor interface {
+func (c ProcessorConfig) Validate() error {
+	switch c.Run {
+	case RunAlways:
+		return nil
+	case RunIfEmpty:
+		if c.TargetField == "" {
+			return fmt.Errorf("processor %s: target_field is required for run: %s", c.Name, c.Run)
+		}
+		return nil
+	case RunIfSourceChanged:
+		if c.SourceField == "" {
+			return fmt.Errorf("processor %s: source_field is required for run: %s", c.Name, c.Run)
+		}
+		return nil
+	default:
+		return fmt.Errorf("processor %s: invalid run policy: %s", c.Name, c.Run)
+	}
+}
+
+// Note is the page a processor runs on. Fields must not be modified; changes
+// are returned in the Result instead. Media stores files in the Anki
+// collection of the job the page belongs to.
+type Note struct {
+	PageID string
+	Fields map[string]string
+	Media  MediaStore
+}
+
+type Result struct {
+	// Fields holds the note fields the processor changed.
+	Fields map[string]string
+	// Notion holds the Notion properties to write back to the page.
+	Notion map[string]string
+	// Warnings are logged but do not fail the processor.
+	Warnings []string
+	// Retryable marks an error as transient, so the page is processed
+	// again on the next sync.
+	Retryable bool
+}
+
+type Processor interface {
 	Name() string
-	Process(noteData *map[string]string, config ProcessorConfig) error
+	Run(ctx context.Context, note Note, config ProcessorConfig) (Result, error)
+}
+
+// ConfigValidator is implemented by processors that check their settings when
+// the configuration is loaded.
+type ConfigValidator interface {
+	ValidateConfig(config ProcessorConfig) error
+}
+
+type MediaStore interface {
+	StoreMediaFile(ctx context.Context, filename, data string) (string, error)
+	MediaFileExists(ctx context.Context, filename string) (bool, error)
+}
+
+type CacheEntry struct {
+	Value string
+	// Found is false when the lookup had no result, so misses are cached too.
+	Found bool
+}
+
+// Cache keeps lookup results across syncs. Inputs are normalised by the
+// cache, so processors can pass them as they are.
+type Cache interface {
+	Get(processor, input string) (CacheEntry, bool)
+	Put(processor, input string, entry CacheEntry) error
+}
+
+type CacheSetter interface {
+	SetCache(cache Cache)
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+)
+
+type PropertyFormatConfig struct {
+	DateLayout      string
+	DateTimeLayout  string
+	DateSeparator   string
+	NumberPrecision int
+	CheckboxTrue    string
+	CheckboxFalse   string
+	ListSeparator   string
+	EmptyValue      string
+	RichTextHTML    bool
+}
+
+type propertyConverter func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string
+
+var propertyConverters map[notion.DatabasePropertyType]propertyConverter
+
+func init() {
+	propertyConverters = map[notion.DatabasePropertyType]propertyConverter{
+		notion.DBPropTypeTitle: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return plainText(prop.Title)
+		},
+		notion.DBPropTypeRichText: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			if format.RichTextHTML {
+				return renderRichText(prop.RichText)
+			}
+			return plainText(prop.RichText)
+		},
+		notion.DBPropTypeNumber: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return format.number(prop.Number)
+		},
+		notion.DBPropTypeSelect: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return selectName(prop.Select)
+		},
+		notion.DBPropTypeStatus: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return selectName(prop.Status)
+		},
+		notion.DBPropTypeMultiSelect: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			var values []string
+			for _, option := range prop.MultiSelect {
+				if option.Name != "" {
+					values = append(values, option.Name)
+				}
+			}
+			return strings.Join(values, format.ListSeparator)
+		},
+		notion.DBPropTypeDate: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return format.date(prop.Date)
+		},
+		notion.DBPropTypeCheckbox: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return format.checkbox(prop.Checkbox)
+		},
+		notion.DBPropTypeURL: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return stringValue(prop.URL)
+		},
+		notion.DBPropTypeEmail: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return stringValue(prop.Email)
+		},
+		notion.DBPropTypePhoneNumber: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return stringValue(prop.PhoneNumber)
+		},
+		notion.DBPropTypeFormula: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			if prop.Formula == nil {
+				return ""
+			}
+			switch prop.Formula.Type {
+			case notion.FormulaResultTypeString:
+				return stringValue(prop.Formula.String)
+			case notion.FormulaResultTypeNumber:
+				return format.number(prop.Formula.Number)
+			case notion.FormulaResultTypeBoolean:
+				return format.checkbox(prop.Formula.Boolean)
+			case notion.FormulaResultTypeDate:
+				return format.date(prop.Formula.Date)
+			}
+			return ""
+		},
+		notion.DBPropTypeRollup: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			if prop.Rollup == nil {
+				return ""
+			}
+			switch prop.Rollup.Type {
+			case notion.RollupResultTypeNumber:
+				return format.number(prop.Rollup.Number)
+			case notion.RollupResultTypeDate:
+				return format.date(prop.Rollup.Date)
+			case notion.RollupResultTypeArray:
+				var values []string
+				for _, item := range prop.Rollup.Array {
+					if value := convertProperty(item, format); value != "" {
+						values = append(values, value)
+					}
+				}
+				return strings.Join(values, format.ListSeparator)
+			}
+			return ""
+		},
+		notion.DBPropTypeRelation: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			var values []string
+			for _, relation := range prop.Relation {
+				values = append(values, relation.ID)
+			}
+			return strings.Join(values, format.ListSeparator)
+		},
+		notion.DBPropTypePeople: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			var values []string
+			for _, user := range prop.People {
+				if user.Name != "" {
+					values = append(values, user.Name)
+				}
+			}
+			return strings.Join(values, format.ListSeparator)
+		},
+		notion.DBPropTypeFiles: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			var values []string
+			for _, file := range prop.Files {
+				if url := fileURL(file); url != "" {
+					values = append(values, url)
+				}
+			}
+			return strings.Join(values, format.ListSeparator)
+		},
+		notion.DBPropTypeCreatedTime: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return format.timestamp(prop.CreatedTime)
+		},
+		notion.DBPropTypeLastEditedTime: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return format.timestamp(prop.LastEditedTime)
+		},
+		notion.DBPropTypeCreatedBy: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return userName(prop.CreatedBy)
+		},
+		notion.DBPropTypeLastEditedBy: func(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+			return userName(prop.LastEditedBy)
+		},
+	}
+}
+
+func convertProperty(prop notion.DatabasePageProperty, format PropertyFormatConfig) string {
+	converter, exist := propertyConverters[prop.Type]
+	if !exist {
+		return ""
+	}
+	return converter(prop, format)
+}
+
+func (format PropertyFormatConfig) number(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', format.NumberPrecision, 64)
+}
+
+func (format PropertyFormatConfig) checkbox(value *bool) string {
+	if value == nil {
+		return ""
+	}
+	if *value {
+		return format.CheckboxTrue
+	}
+	return format.CheckboxFalse
+}
+
+func (format PropertyFormatConfig) dateTime(value notion.DateTime) string {
+	if value.HasTime() {
+		return value.Time.Format(format.DateTimeLayout)
+	}
+	return value.Time.Format(format.DateLayout)
+}
+
+func (format PropertyFormatConfig) date(value *notion.Date) string {
+	if value == nil {
+		return ""
+	}
+	result := format.dateTime(value.Start)
+	if value.End != nil {
+		result += format.DateSeparator + format.dateTime(*value.End)
+	}
+	return result
+}
+
+func (format PropertyFormatConfig) timestamp(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.Format(format.DateTimeLayout)
+}
+
+func plainText(richText []notion.RichText) string {
+	var builder strings.Builder
+	for _, text := range richText {
+		builder.WriteString(text.PlainText)
+	}
+	return builder.String()
+}
+
+func selectName(option *notion.SelectOptions) string {
+	if option == nil {
+		return ""
+	}
+	return option.Name
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func userName(user *notion.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.Name
+}
+
+func fileURL(file notion.File) string {
+	switch {
+	case file.File != nil:
+		return file.File.URL
+	case file.External != nil:
+		return file.External.URL
+	}
+	return ""
+}
+
+func pageTitle(page notion.Page) string {
+	if dbProps, ok := page.Properties.(notion.DatabasePageProperties); ok {
+		for _, prop := range dbProps {
+			if prop.Type == notion.DBPropTypeTitle {
+				return plainText(prop.Title)
+			}
+		}
+	}
+	return page.ID
+}
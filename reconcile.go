@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+const (
+	DeletionPolicyNone    = "none"
+	DeletionPolicyDelete  = "delete"
+	DeletionPolicySuspend = "suspend"
+	DeletionPolicyTag     = "tag"
+)
+
+type DeletionConfig struct {
+	Policy string
+	Tag    string
+}
+
+func (cfg DeletionConfig) Validate() error {
+	switch cfg.Policy {
+	case DeletionPolicyNone, DeletionPolicyDelete, DeletionPolicySuspend:
+		return nil
+	case DeletionPolicyTag:
+		if cfg.Tag == "" {
+			return fmt.Errorf("deletion.tag is required for the %q policy", DeletionPolicyTag)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid deletion.policy: %s", cfg.Policy)
+	}
+}
+
+func reconcileDeletedPages(ctx context.Context, anki *Anki, nt *NotionClient, cfg DeletionConfig, state *SyncState, plan *SyncPlan) error {
+	if cfg.Policy == DeletionPolicyNone || len(state.PageNotes) == 0 {
+		return nil
+	}
+
+	livePages, err := nt.QueryAllPageIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(livePages) == 0 {
+		anki.logger.Printf("Notion database returned no pages, skipping deletion of %d mapped notes", len(state.PageNotes))
+		return nil
+	}
+
+	var removedPages []string
+	var noteIDs []int64
+	for pageID, noteID := range state.PageNotes {
+		if livePages[pageID] {
+			continue
+		}
+		removedPages = append(removedPages, pageID)
+		noteIDs = append(noteIDs, noteID)
+	}
+
+	if len(removedPages) == 0 {
+		return nil
+	}
+	sort.Strings(removedPages)
+
+	if plan != nil {
+		for _, pageID := range removedPages {
+			plan.Delete = append(plan.Delete, PlannedNote{PageID: pageID, NoteID: state.PageNotes[pageID], Action: cfg.Policy})
+		}
+		return nil
+	}
+
+	anki.logger.Printf("%d pages were removed from Notion, applying %q policy to their notes", len(removedPages), cfg.Policy)
+
+	switch cfg.Policy {
+	case DeletionPolicyDelete:
+		err = anki.DeleteNotes(ctx, noteIDs)
+	case DeletionPolicySuspend:
+		err = anki.SuspendNotes(ctx, noteIDs)
+	case DeletionPolicyTag:
+		err = anki.AddTags(ctx, noteIDs, cfg.Tag)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, pageID := range removedPages {
+		anki.logger.Printf("Page %s removed, %s note %d", pageID, cfg.Policy, state.PageNotes[pageID])
+		delete(state.PageNotes, pageID)
+		delete(state.PageStats, pageID)
+		delete(state.PageTags, pageID)
+		delete(state.RetryPages, pageID)
+		delete(state.OwnEdits, pageID)
+		delete(state.ProcessorSources, pageID)
+	}
+
+	return nil
+}
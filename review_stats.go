@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+)
+
+const (
+	cardTypeNew      = 0
+	cardTypeReview   = 2
+	cardQueueSuspend = -1
+)
+
+type ReviewStatsConfig struct {
+	Enabled            bool
+	MatureIntervalDays int
+	IntervalProperty   string
+	EaseProperty       string
+	LapsesProperty     string
+	ReviewsProperty    string
+	DueProperty        string
+	StatusProperty     string
+}
+
+type reviewStats struct {
+	Interval int
+	Ease     int
+	Lapses   int
+	Reviews  int
+	Due      time.Time
+	Status   string
+}
+
+func (cfg ReviewStatsConfig) properties(stats reviewStats) map[string]string {
+	props := map[string]string{}
+	if cfg.IntervalProperty != "" {
+		props[cfg.IntervalProperty] = strconv.Itoa(stats.Interval)
+	}
+	if cfg.EaseProperty != "" {
+		props[cfg.EaseProperty] = strconv.Itoa(stats.Ease)
+	}
+	if cfg.LapsesProperty != "" {
+		props[cfg.LapsesProperty] = strconv.Itoa(stats.Lapses)
+	}
+	if cfg.ReviewsProperty != "" {
+		props[cfg.ReviewsProperty] = strconv.Itoa(stats.Reviews)
+	}
+	if cfg.DueProperty != "" && !stats.Due.IsZero() {
+		props[cfg.DueProperty] = stats.Due.Format("2006-01-02")
+	}
+	if cfg.StatusProperty != "" {
+		props[cfg.StatusProperty] = stats.Status
+	}
+	return props
+}
+
+func (cfg ReviewStatsConfig) propertyNames() []string {
+	var names []string
+	for _, name := range []string{cfg.IntervalProperty, cfg.EaseProperty, cfg.LapsesProperty, cfg.ReviewsProperty, cfg.DueProperty, cfg.StatusProperty} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func syncReviewStats(ctx context.Context, anki *Anki, nt *NotionClient, cfg ReviewStatsConfig, state *SyncState, schema notion.DatabaseProperties, pages map[string]notion.Page) error {
+	if !cfg.Enabled || len(state.PageNotes) == 0 {
+		return nil
+	}
+
+	noteIDs := make([]int64, 0, len(state.PageNotes))
+	pageByNote := make(map[int64]string, len(state.PageNotes))
+	for pageID, noteID := range state.PageNotes {
+		noteIDs = append(noteIDs, noteID)
+		pageByNote[noteID] = pageID
+	}
+
+	notes, err := anki.NotesInfo(ctx, noteIDs)
+	if err != nil {
+		return err
+	}
+
+	var cardIDs []int64
+	leeches := map[int64]bool{}
+	for _, note := range notes {
+		cardIDs = append(cardIDs, note.Cards...)
+		for _, tag := range note.Tags {
+			if strings.EqualFold(tag, "leech") {
+				leeches[note.NoteID] = true
+			}
+		}
+	}
+	if len(cardIDs) == 0 {
+		return nil
+	}
+
+	cards, err := anki.CardsInfo(ctx, cardIDs)
+	if err != nil {
+		return err
+	}
+
+	var reviews map[int64][]AnkiReview
+	if cfg.DueProperty != "" {
+		reviews, err = anki.GetReviewsOfCards(ctx, cardIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	cardsByNote := map[int64][]AnkiCardInfo{}
+	for _, card := range cards {
+		cardsByNote[card.NoteID] = append(cardsByNote[card.NoteID], card)
+	}
+
+	if state.PageStats == nil {
+		state.PageStats = map[string]string{}
+	}
+
+	written := 0
+	for noteID, noteCards := range cardsByNote {
+		pageID, exist := pageByNote[noteID]
+		if !exist {
+			continue
+		}
+
+		stats := cfg.computeStats(noteCards, reviews, leeches[noteID])
+		props := cfg.properties(stats)
+		for name := range props {
+			if _, exist := schema[name]; !exist {
+				delete(props, name)
+			}
+		}
+		if len(props) == 0 {
+			continue
+		}
+		fingerprint := fmt.Sprint(props)
+		if state.PageStats[pageID] == fingerprint {
+			continue
+		}
+
+		// Pages not read in this sync are fetched, so the write can be told
+		// apart from other edits.
+		page, exist := pages[pageID]
+		if !exist {
+			if page, err = nt.Client.FindPageByID(ctx, pageID); err != nil {
+				anki.logger.Printf("Failed to read Notion page %s: %v", pageID, err)
+				continue
+			}
+		}
+		if _, err := writePage(ctx, nt, state, page, props, schema); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			anki.logger.Printf("Failed to write review stats to Notion page %s: %v", pageID, err)
+			continue
+		}
+		state.PageStats[pageID] = fingerprint
+		written++
+	}
+
+	if written > 0 {
+		anki.logger.Printf("Wrote review stats for %d pages to Notion.", written)
+	}
+	return nil
+}
+
+func (cfg ReviewStatsConfig) computeStats(cards []AnkiCardInfo, reviews map[int64][]AnkiReview, leech bool) reviewStats {
+	stats := reviewStats{Interval: -1}
+	var weakest AnkiCardInfo
+	suspended := true
+
+	for _, card := range cards {
+		stats.Lapses += card.Lapses
+		stats.Reviews += card.Reps
+		if card.Queue != cardQueueSuspend {
+			suspended = false
+		}
+		if stats.Interval == -1 || card.Interval < stats.Interval {
+			stats.Interval = card.Interval
+			weakest = card
+		}
+		if card.Factor > 0 && (stats.Ease == 0 || card.Factor/10 < stats.Ease) {
+			stats.Ease = card.Factor / 10
+		}
+
+		if card.Type != cardTypeReview || card.Interval <= 0 {
+			continue
+		}
+		var lastReviewID int64
+		for _, review := range reviews[card.CardID] {
+			if review.ID > lastReviewID {
+				lastReviewID = review.ID
+			}
+		}
+		if lastReviewID == 0 {
+			continue
+		}
+		lastReview := time.UnixMilli(lastReviewID)
+		due := lastReview.AddDate(0, 0, card.Interval)
+		if stats.Due.IsZero() || due.Before(stats.Due) {
+			stats.Due = due
+		}
+	}
+	if stats.Interval < 0 {
+		stats.Interval = 0
+	}
+
+	switch {
+	case leech:
+		stats.Status = "Leech"
+	case suspended:
+		stats.Status = "Suspended"
+	case weakest.Type == cardTypeNew:
+		stats.Status = "New"
+	case weakest.Type == cardTypeReview && weakest.Interval >= cfg.MatureIntervalDays:
+		stats.Status = "Mature"
+	case weakest.Type == cardTypeReview:
+		stats.Status = "Young"
+	default:
+		stats.Status = "Learning"
+	}
+
+	return stats
+}
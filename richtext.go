@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+var notionColors = map[notion.Color]string{
+	notion.ColorGray:     "#787774",
+	notion.ColorBrown:    "#9f6b53",
+	notion.ColorOrange:   "#d9730d",
+	notion.ColorYellow:   "#cb912f",
+	notion.ColorGreen:    "#448361",
+	notion.ColorBlue:     "#337ea9",
+	notion.ColorPurple:   "#9065b0",
+	notion.ColorPink:     "#c14c8a",
+	notion.ColorRed:      "#d44c47",
+	notion.ColorGrayBg:   "#f1f1ef",
+	notion.ColorBrownBg:  "#f4eeee",
+	notion.ColorOrangeBg: "#fbecdd",
+	notion.ColorYellowBg: "#fbf3db",
+	notion.ColorGreenBg:  "#edf3ec",
+	notion.ColorBlueBg:   "#e7f3f8",
+	notion.ColorPurpleBg: "#f6f3f9",
+	notion.ColorPinkBg:   "#faf1f5",
+	notion.ColorRedBg:    "#fdebec",
+}
+
+func renderRichText(richText []notion.RichText) string {
+	var builder strings.Builder
+	for _, text := range richText {
+		builder.WriteString(renderRichTextRun(text))
+	}
+	return builder.String()
+}
+
+func renderRichTextRun(text notion.RichText) string {
+	var content string
+	switch text.Type {
+	case notion.RichTextTypeEquation:
+		expression := text.PlainText
+		if text.Equation != nil {
+			expression = text.Equation.Expression
+		}
+		content = `\(` + html.EscapeString(expression) + `\)`
+	case notion.RichTextTypeMention:
+		content = renderMention(text)
+	default:
+		value := text.PlainText
+		if value == "" && text.Text != nil {
+			value = text.Text.Content
+		}
+		content = strings.ReplaceAll(html.EscapeString(value), "\n", "<br>")
+	}
+
+	if content == "" {
+		return ""
+	}
+
+	if annotations := text.Annotations; annotations != nil {
+		if annotations.Code {
+			content = "<code>" + content + "</code>"
+		}
+		if annotations.Bold {
+			content = "<b>" + content + "</b>"
+		}
+		if annotations.Italic {
+			content = "<i>" + content + "</i>"
+		}
+		if annotations.Underline {
+			content = "<u>" + content + "</u>"
+		}
+		if annotations.Strikethrough {
+			content = "<s>" + content + "</s>"
+		}
+		content = colorize(content, annotations.Color)
+	}
+
+	if href := richTextLink(text); href != "" && text.Type != notion.RichTextTypeMention {
+		content = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), content)
+	}
+
+	return content
+}
+
+func renderMention(text notion.RichText) string {
+	label := html.EscapeString(text.PlainText)
+	if text.Mention != nil && text.Mention.Type == notion.MentionTypeUser && !strings.HasPrefix(text.PlainText, "@") {
+		label = "@" + label
+	}
+	if href := richTextLink(text); href != "" {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), label)
+	}
+	return label
+}
+
+func richTextLink(text notion.RichText) string {
+	if text.HRef != nil && *text.HRef != "" {
+		return *text.HRef
+	}
+	if text.Text != nil && text.Text.Link != nil {
+		return text.Text.Link.URL
+	}
+	return ""
+}
+
+func colorize(content string, color notion.Color) string {
+	value, exist := notionColors[color]
+	if !exist {
+		return content
+	}
+	property := "color"
+	if strings.HasSuffix(string(color), "_background") {
+		property = "background-color"
+	}
+	return fmt.Sprintf(`<span style="%s: %s">%s</span>`, property, value, content)
+}
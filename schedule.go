@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+type ScheduleConfig struct {
+	Interval   time.Duration
+	Jitter     time.Duration
+	Cron       string
+	QuietHours string
+	BackoffMin time.Duration
+	BackoffMax time.Duration
+}
+
+type Schedule struct {
+	cfg        ScheduleConfig
+	cron       cron.Schedule
+	quiet      bool
+	quietStart int
+	quietEnd   int
+}
+
+func NewSchedule(cfg ScheduleConfig) (*Schedule, error) {
+	schedule := &Schedule{cfg: cfg}
+
+	if cfg.Cron != "" {
+		parsed, err := cron.ParseStandard(cfg.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule.cron %q: %v", cfg.Cron, err)
+		}
+		schedule.cron = parsed
+	} else if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("notion.poll_interval_seconds must be greater than zero")
+	}
+
+	if cfg.Jitter < 0 {
+		return nil, fmt.Errorf("schedule.jitter_seconds must not be negative")
+	}
+	if cfg.BackoffMin <= 0 || cfg.BackoffMax < cfg.BackoffMin {
+		return nil, fmt.Errorf("schedule.backoff needs 0 < min_seconds <= max_seconds")
+	}
+
+	if cfg.QuietHours != "" {
+		start, end, found := strings.Cut(cfg.QuietHours, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid schedule.quiet_hours %q, expected HH:MM-HH:MM", cfg.QuietHours)
+		}
+		var err error
+		if schedule.quietStart, err = minuteOfDay(start); err != nil {
+			return nil, fmt.Errorf("invalid schedule.quiet_hours %q: %v", cfg.QuietHours, err)
+		}
+		if schedule.quietEnd, err = minuteOfDay(end); err != nil {
+			return nil, fmt.Errorf("invalid schedule.quiet_hours %q: %v", cfg.QuietHours, err)
+		}
+		schedule.quiet = schedule.quietStart != schedule.quietEnd
+	}
+
+	return schedule, nil
+}
+
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s *Schedule) String() string {
+	var description string
+	if s.cron != nil {
+		description = "cron " + s.cfg.Cron
+	} else {
+		description = "every " + s.cfg.Interval.String()
+		if s.cfg.Jitter > 0 {
+			description += " ± " + s.cfg.Jitter.String()
+		}
+	}
+	if s.quiet {
+		description += ", quiet hours " + s.cfg.QuietHours
+	}
+	return description
+}
+
+func (s *Schedule) First(now time.Time) time.Time {
+	return s.afterQuietHours(now)
+}
+
+func (s *Schedule) Next(now time.Time, failures int) time.Time {
+	var next time.Time
+	switch {
+	case failures > 0:
+		next = now.Add(s.Backoff(failures))
+	case s.cron != nil:
+		next = s.cron.Next(now)
+	default:
+		delay := s.cfg.Interval
+		if s.cfg.Jitter > 0 {
+			delay += time.Duration(rand.Int64N(int64(2*s.cfg.Jitter))) - s.cfg.Jitter
+		}
+		next = now.Add(max(delay, time.Second))
+	}
+	return s.afterQuietHours(next)
+}
+
+func (s *Schedule) Backoff(failures int) time.Duration {
+	delay := s.cfg.BackoffMin
+	for i := 1; i < failures && delay < s.cfg.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.BackoffMax)
+}
+
+func (s *Schedule) afterQuietHours(t time.Time) time.Time {
+	if !s.quiet {
+		return t
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	var quiet bool
+	if s.quietStart < s.quietEnd {
+		quiet = minute >= s.quietStart && minute < s.quietEnd
+	} else {
+		quiet = minute >= s.quietStart || minute < s.quietEnd
+	}
+	if !quiet {
+		return t
+	}
+
+	end := time.Date(t.Year(), t.Month(), t.Day(), s.quietEnd/60, s.quietEnd%60, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dstotijn/go-notion"
+)
+
+func schemaWarnings(cfg *JobConfig, schema notion.DatabaseProperties) []string {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	for _, processConfig := range cfg.Processors {
+		if !processConfig.Enabled {
+			continue
+		}
+		if processConfig.SourceField != "" && !hasProperty(schema, processConfig.SourceField) &&
+			!(cfg.PageContent.Enabled && processConfig.SourceField == cfg.PageContent.TargetField) {
+			warn("Processor %s: source field %s does not exist in the Notion database", processConfig.Name, processConfig.SourceField)
+		}
+		if processConfig.TargetField == "" {
+			continue
+		}
+		prop, exist := schema[processConfig.TargetField]
+		if !exist {
+			warn("Processor %s: target field %s does not exist in the Notion database", processConfig.Name, processConfig.TargetField)
+			continue
+		}
+		if !isWritableProperty(prop.Type) {
+			warn("Processor %s: target field %s has type %q, which cannot be written", processConfig.Name, processConfig.TargetField, prop.Type)
+		}
+	}
+
+	for _, mapping := range cfg.FieldMapper.mappings {
+		if mapping.Property != "" && !hasProperty(schema, mapping.Property) &&
+			!(cfg.PageContent.Enabled && mapping.Property == cfg.PageContent.TargetField) {
+			warn("Mapping for field %s: property %s does not exist in the Notion database", mapping.Field, mapping.Property)
+		}
+	}
+
+	for _, rule := range cfg.Tags {
+		prop, exist := schema[rule.Property]
+		if !exist {
+			warn("Tag property %s does not exist in the Notion database", rule.Property)
+			continue
+		}
+		if !isTagProperty(prop.Type) {
+			warn("Tag property %s has type %q, only select, multi-select and status become tags", rule.Property, prop.Type)
+		}
+	}
+
+	if cfg.ReviewStats.Enabled {
+		for _, name := range cfg.ReviewStats.propertyNames() {
+			prop, exist := schema[name]
+			if !exist {
+				warn("Review stats property %s does not exist in the Notion database and will be skipped", name)
+				continue
+			}
+			if !isWritableProperty(prop.Type) {
+				warn("Review stats property %s has type %q, which cannot be written", name, prop.Type)
+			}
+		}
+	}
+	return warnings
+}
+
+func hasProperty(schema notion.DatabaseProperties, name string) bool {
+	_, exist := schema[name]
+	return exist
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type StateConfig struct {
+	Driver string
+	Path   string
+}
+
+type SyncState struct {
+	DatabaseID       string                       `json:"database_id"`
+	LastSyncTime     time.Time                    `json:"last_sync_time"`
+	PageNotes        map[string]int64             `json:"page_notes"`
+	PageStats        map[string]string            `json:"page_stats,omitempty"`
+	PageTags         map[string][]string          `json:"page_tags,omitempty"`
+	Media            map[string]string            `json:"media,omitempty"`
+	PropertyNames    map[string]string            `json:"property_names,omitempty"`
+	RetryPages       map[string]bool              `json:"retry_pages,omitempty"`
+	OwnEdits         map[string]string            `json:"own_edits,omitempty"`
+	ProcessorSources map[string]map[string]string `json:"processor_sources,omitempty"`
+}
+
+type StateStore interface {
+	Load(databaseID string) (*SyncState, error)
+	Save(state *SyncState) error
+}
+
+type stateFile struct {
+	Databases map[string]*SyncState `json:"databases"`
+}
+
+type JSONStateStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewStateStore(cfg StateConfig) (StateStore, error) {
+	switch cfg.Driver {
+	case "", "json":
+		if cfg.Path == "" {
+			return nil, errors.New("state.path is required for the json state driver")
+		}
+		return NewJSONStateStore(cfg.Path), nil
+	default:
+		return nil, fmt.Errorf("unknown state driver: %s", cfg.Driver)
+	}
+}
+
+func NewJSONStateStore(path string) *JSONStateStore {
+	return &JSONStateStore{path: path}
+}
+
+func newSyncState(databaseID string) *SyncState {
+	return &SyncState{
+		DatabaseID: databaseID,
+		PageNotes:  map[string]int64{},
+	}
+}
+
+func (s *JSONStateStore) readFile() (*stateFile, error) {
+	file := &stateFile{Databases: map[string]*SyncState{}}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to read state file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("fail to parse state file %s: %v", s.path, err)
+	}
+	if file.Databases == nil {
+		file.Databases = map[string]*SyncState{}
+	}
+	return file, nil
+}
+
+func (s *JSONStateStore) Load(databaseID string) (*SyncState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := s.readFile()
+	if err != nil {
+		return nil, err
+	}
+
+	state, exist := file.Databases[databaseID]
+	if !exist {
+		return newSyncState(databaseID), nil
+	}
+	state.DatabaseID = databaseID
+	if state.PageNotes == nil {
+		state.PageNotes = map[string]int64{}
+	}
+	return state, nil
+}
+
+func (s *JSONStateStore) Save(state *SyncState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := s.readFile()
+	if err != nil {
+		return err
+	}
+	file.Databases[state.DatabaseID] = state
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("fail to serialize state: %v", err)
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("fail to write state file: %v", err)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("fail to create directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("fail to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("fail to flush: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
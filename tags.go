@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+type TagRule struct {
+	Property string `mapstructure:"property"`
+	Prefix   string `mapstructure:"prefix"`
+}
+
+func validateTagRules(rules []TagRule) error {
+	for i, rule := range rules {
+		if rule.Property == "" {
+			return fmt.Errorf("anki.tags[%d]: property is required", i)
+		}
+		if strings.ContainsAny(rule.Prefix, " \t") {
+			return fmt.Errorf("anki.tags[%d]: prefix %q must not contain spaces", i, rule.Prefix)
+		}
+	}
+	return nil
+}
+
+func isTagProperty(propType notion.DatabasePropertyType) bool {
+	switch propType {
+	case notion.DBPropTypeSelect, notion.DBPropTypeMultiSelect, notion.DBPropTypeStatus:
+		return true
+	}
+	return false
+}
+
+func pageTags(page notion.Page, rules []TagRule) []string {
+	dbProps, ok := page.Properties.(notion.DatabasePageProperties)
+	if !ok || len(rules) == 0 {
+		return nil
+	}
+
+	var tags []string
+	for _, rule := range rules {
+		prop, exist := dbProps[rule.Property]
+		if !exist {
+			continue
+		}
+
+		var values []string
+		switch prop.Type {
+		case notion.DBPropTypeSelect:
+			values = append(values, selectName(prop.Select))
+		case notion.DBPropTypeStatus:
+			values = append(values, selectName(prop.Status))
+		case notion.DBPropTypeMultiSelect:
+			for _, option := range prop.MultiSelect {
+				values = append(values, option.Name)
+			}
+		}
+
+		for _, value := range values {
+			tag := normalizeTag(value)
+			if tag == "" {
+				continue
+			}
+			tag = rule.Prefix + tag
+			if !containsTag(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+func normalizeTag(value string) string {
+	var parts []string
+	for _, part := range strings.Split(value, "::") {
+		part = strings.Join(strings.Fields(part), "_")
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "::")
+}
+
+func containsTag(tags []string, tag string) bool {
+	return slices.ContainsFunc(tags, func(existing string) bool {
+		return strings.EqualFold(existing, tag)
+	})
+}
+
+func noteTags(pageID string, tags []string) []string {
+	return append([]string{notionTag, PageTag(pageID)}, tags...)
+}
+
+func setPageTags(state *SyncState, pageID string, tags []string) {
+	if len(tags) == 0 {
+		delete(state.PageTags, pageID)
+		return
+	}
+	if state.PageTags == nil {
+		state.PageTags = map[string][]string{}
+	}
+	state.PageTags[pageID] = tags
+}
+
+func (anki *Anki) UpdateNoteTags(ctx context.Context, noteID int64, tags []string) error {
+	request := AnkiConnectRequest{
+		Action:  "updateNoteTags",
+		Version: 6,
+		Params: map[string]interface{}{
+			"note": noteID,
+			"tags": tags,
+		},
+	}
+
+	var response AnkiConnectResponse
+	if err := anki.makeJSONRequest(ctx, request, &response); err != nil {
+		return fmt.Errorf("fail to update note tags: %v", err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("AnkiConnect update note tags error: %v", response.Error)
+	}
+
+	return nil
+}
+
+func mergeTags(current, previous, desired []string) ([]string, bool) {
+	var tags []string
+	for _, tag := range current {
+		if containsTag(previous, tag) && !containsTag(desired, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	for _, tag := range desired {
+		if !containsTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	changed := len(tags) != len(current) || slices.ContainsFunc(tags, func(tag string) bool {
+		return !containsTag(current, tag)
+	})
+	return tags, changed
+}
+
+func (anki *Anki) SyncNoteTags(ctx context.Context, note *AnkiNoteInfo, previous, desired []string) (bool, error) {
+	tags, changed := mergeTags(note.Tags, previous, desired)
+	if !changed {
+		return false, nil
+	}
+
+	if err := anki.UpdateNoteTags(ctx, note.NoteID, tags); err != nil {
+		return false, err
+	}
+	anki.logger.Printf("Updated note %d tags: %v", note.NoteID, tags)
+	note.Tags = tags
+	return true, nil
+}
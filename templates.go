@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const defaultModelCSS = `.card {
+  font-family: arial;
+  font-size: 20px;
+  text-align: center;
+  color: black;
+  background-color: white;
+}
+
+.field {
+  margin: 0.4em 0;
+}
+
+.label {
+  color: #787774;
+  font-size: 0.8em;
+}
+`
+
+type CardTemplateConfig struct {
+	FrontFile        string
+	BackFile         string
+	CSSFile          string
+	Reverse          bool
+	ReverseFrontFile string
+	ReverseBackFile  string
+	UpdateExisting   bool
+}
+
+type CardTemplate struct {
+	Name  string `json:"Name"`
+	Front string `json:"Front"`
+	Back  string `json:"Back"`
+}
+
+func (cfg CardTemplateConfig) Build(fields []string) ([]CardTemplate, string, error) {
+	if len(fields) == 0 {
+		return nil, "", errors.New("cannot build card templates without fields")
+	}
+
+	front, back := defaultCardTemplate(fields[0], fields[1:])
+	forward, err := loadCardTemplate("Card 1", cfg.FrontFile, cfg.BackFile, front, back)
+	if err != nil {
+		return nil, "", err
+	}
+	templates := []CardTemplate{forward}
+
+	if cfg.Reverse {
+		if len(fields) < 2 {
+			return nil, "", errors.New("a reverse card needs at least two fields")
+		}
+		front, back := defaultCardTemplate(fields[1], []string{fields[0]})
+		reverse, err := loadCardTemplate("Card 2", cfg.ReverseFrontFile, cfg.ReverseBackFile, front, back)
+		if err != nil {
+			return nil, "", err
+		}
+		templates = append(templates, reverse)
+	}
+
+	css := defaultModelCSS
+	if cfg.CSSFile != "" {
+		data, err := os.ReadFile(cfg.CSSFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("fail to read model CSS: %v", err)
+		}
+		css = string(data)
+	}
+
+	return templates, css, nil
+}
+
+func loadCardTemplate(name, frontFile, backFile, defaultFront, defaultBack string) (CardTemplate, error) {
+	template := CardTemplate{Name: name, Front: defaultFront, Back: defaultBack}
+	if frontFile != "" {
+		data, err := os.ReadFile(frontFile)
+		if err != nil {
+			return CardTemplate{}, fmt.Errorf("fail to read front template of %s: %v", name, err)
+		}
+		template.Front = string(data)
+	}
+	if backFile != "" {
+		data, err := os.ReadFile(backFile)
+		if err != nil {
+			return CardTemplate{}, fmt.Errorf("fail to read back template of %s: %v", name, err)
+		}
+		template.Back = string(data)
+	}
+	return template, nil
+}
+
+func defaultCardTemplate(frontField string, backFields []string) (string, string) {
+	front := fmt.Sprintf("<div class=\"front\">{{%s}}</div>", frontField)
+
+	var back strings.Builder
+	back.WriteString("{{FrontSide}}\n\n<hr id=answer>\n")
+	for _, field := range backFields {
+		fmt.Fprintf(&back, "\n{{#%[1]s}}<div class=\"field\"><span class=\"label\">%[1]s</span><br>{{%[1]s}}</div>{{/%[1]s}}", field)
+	}
+	return front, back.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+)
+
+func (job *SyncJob) writeBack(ctx context.Context, page notion.Page, values map[string]string, schema notion.DatabaseProperties) (notion.Page, error) {
+	changed := changedProperties(page, values, job.Notion.Format.ListSeparator)
+	if len(changed) == 0 {
+		return page, nil
+	}
+	return writePage(ctx, job.Notion, job.State, page, changed, schema)
+}
+
+// writePage updates the page and remembers the edit as our own, so it does
+// not trigger another sync. It returns the page as it is after the update.
+func writePage(ctx context.Context, nt *NotionClient, state *SyncState, page notion.Page, props map[string]string, schema notion.DatabaseProperties) (notion.Page, error) {
+	updated, err := nt.UpdatePageOfDatabase(ctx, page.ID, props, schema)
+	if err != nil {
+		return page, err
+	}
+	if updated.ID == "" {
+		return page, nil
+	}
+
+	// Someone else edited the page since it was read, so the next poll
+	// must still pick it up.
+	if propertiesFingerprint(page, props) != propertiesFingerprint(updated, props) {
+		return updated, nil
+	}
+	if state.OwnEdits == nil {
+		state.OwnEdits = map[string]string{}
+	}
+	state.OwnEdits[page.ID] = ownEditMark(updated)
+	return updated, nil
+}
+
+func changedProperties(page notion.Page, values map[string]string, listSeparator string) map[string]string {
+	dbProps, _ := page.Properties.(notion.DatabasePageProperties)
+	changed := map[string]string{}
+	for name, value := range values {
+		prop, exist := dbProps[name]
+		if !exist || !propertyHasValue(prop, value, listSeparator) {
+			changed[name] = value
+		}
+	}
+	return changed
+}
+
+func propertyHasValue(prop notion.DatabasePageProperty, value, listSeparator string) bool {
+	switch prop.Type {
+	case notion.DBPropTypeTitle:
+		return plainText(prop.Title) == value
+	case notion.DBPropTypeRichText:
+		return plainText(prop.RichText) == value
+	case notion.DBPropTypeSelect:
+		return selectName(prop.Select) == value
+	case notion.DBPropTypeStatus:
+		return selectName(prop.Status) == value
+	case notion.DBPropTypeMultiSelect:
+		var names []string
+		for _, option := range prop.MultiSelect {
+			names = append(names, option.Name)
+		}
+		return strings.Join(names, listSeparator) == value
+	case notion.DBPropTypeURL:
+		return stringValue(prop.URL) == value
+	case notion.DBPropTypeEmail:
+		return stringValue(prop.Email) == value
+	case notion.DBPropTypePhoneNumber:
+		return stringValue(prop.PhoneNumber) == value
+	case notion.DBPropTypeNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		return err == nil && prop.Number != nil && *prop.Number == number
+	case notion.DBPropTypeCheckbox:
+		checked, err := strconv.ParseBool(value)
+		return err == nil && prop.Checkbox != nil && *prop.Checkbox == checked
+	case notion.DBPropTypeDate:
+		date, err := notion.ParseDateTime(value)
+		return err == nil && prop.Date != nil && prop.Date.Start.Time.Equal(date.Time) &&
+			prop.Date.Start.HasTime() == date.HasTime()
+	}
+	return false
+}
+
+// propertiesFingerprint hashes the page properties, leaving out the skipped
+// ones and the timestamps Notion changes on every edit.
+func propertiesFingerprint(page notion.Page, skip map[string]string) string {
+	dbProps, _ := page.Properties.(notion.DatabasePageProperties)
+	props := notion.DatabasePageProperties{}
+	for name, prop := range dbProps {
+		if _, skipped := skip[name]; skipped {
+			continue
+		}
+		if prop.Type == notion.DBPropTypeLastEditedTime || prop.Type == notion.DBPropTypeLastEditedBy {
+			continue
+		}
+		props[name] = prop
+	}
+
+	data, err := json.Marshal(props)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func ownEditMark(page notion.Page) string {
+	return page.LastEditedTime.UTC().Format(time.RFC3339) + " " + propertiesFingerprint(page, nil)
+}
+
+// isOwnEdit reports whether the last edit of the page was a write-back of
+// this job, which would otherwise make every write trigger another sync.
+func isOwnEdit(state *SyncState, page notion.Page) bool {
+	mark, exist := state.OwnEdits[page.ID]
+	return exist && !state.RetryPages[page.ID] && mark == ownEditMark(page)
+}